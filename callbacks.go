@@ -0,0 +1,61 @@
+package wgorm
+
+import (
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// registerCallbacks hooks wgorm's statement handling into gorm's callback chains.
+// The rows of the Row callbacks outlive them, their timeout ends when the rows are closed.
+func registerCallbacks(db *gorm.DB, conn *connection) error {
+	cb := db.Callback()
+	timeout := newTimeoutHook(conn)
+	tenant := newTenantHook(conn)
+	vars := newSessionVarsHook(conn)
+	page := newPageHook(conn)
+
+	errs := []error{
+		cb.Create().Before("gorm:begin_transaction").Register("wgorm:timeout_before", timeout.before),
+		cb.Create().After("gorm:commit_or_rollback_transaction").Register("wgorm:timeout_after", timeout.after),
+		cb.Query().Before("gorm:query").Register("wgorm:timeout_before", timeout.before),
+		cb.Query().After("gorm:after_query").Register("wgorm:timeout_after", timeout.after),
+		cb.Update().Before("gorm:begin_transaction").Register("wgorm:timeout_before", timeout.before),
+		cb.Update().After("gorm:commit_or_rollback_transaction").Register("wgorm:timeout_after", timeout.after),
+		cb.Delete().Before("gorm:begin_transaction").Register("wgorm:timeout_before", timeout.before),
+		cb.Delete().After("gorm:commit_or_rollback_transaction").Register("wgorm:timeout_after", timeout.after),
+		cb.Raw().Before("gorm:raw").Register("wgorm:timeout_before", timeout.before),
+		cb.Raw().After("gorm:raw").Register("wgorm:timeout_after", timeout.after),
+		cb.Row().Before("gorm:row").Register("wgorm:timeout_before", timeout.rowBefore),
+		cb.Row().After("gorm:row").Register("wgorm:timeout_after", timeout.after),
+		cb.Create().Before("gorm:create").Register("wgorm:tenant", tenant.prefix),
+		cb.Query().Before("gorm:query").Register("wgorm:tenant", tenant.prefix),
+		cb.Update().Before("gorm:update").Register("wgorm:tenant", tenant.prefix),
+		cb.Delete().Before("gorm:delete").Register("wgorm:tenant", tenant.prefix),
+		cb.Row().Before("gorm:row").Register("wgorm:tenant", tenant.prefix),
+		cb.Raw().Before("gorm:raw").Register("wgorm:tenant", tenant.raw),
+		cb.Create().After("gorm:begin_transaction").Before("gorm:before_create").Register("wgorm:session_vars_begin", vars.begin),
+		cb.Create().After("gorm:after_create").Before("gorm:commit_or_rollback_transaction").Register("wgorm:session_vars_end", vars.end),
+		cb.Query().After("wgorm:timeout_before").Before("gorm:query").Register("wgorm:session_vars_begin", vars.begin),
+		cb.Query().After("gorm:after_query").Before("wgorm:timeout_after").Register("wgorm:session_vars_end", vars.end),
+		cb.Update().After("gorm:begin_transaction").Before("gorm:before_update").Register("wgorm:session_vars_begin", vars.begin),
+		cb.Update().After("gorm:after_update").Before("gorm:commit_or_rollback_transaction").Register("wgorm:session_vars_end", vars.end),
+		cb.Delete().After("gorm:begin_transaction").Before("gorm:before_delete").Register("wgorm:session_vars_begin", vars.begin),
+		cb.Delete().After("gorm:after_delete").Before("gorm:commit_or_rollback_transaction").Register("wgorm:session_vars_end", vars.end),
+		cb.Raw().After("wgorm:timeout_before").Before("gorm:raw").Register("wgorm:session_vars_begin", vars.begin),
+		cb.Raw().After("gorm:raw").Before("wgorm:timeout_after").Register("wgorm:session_vars_end", vars.end),
+		cb.Row().After("wgorm:timeout_before").Before("gorm:row").Register("wgorm:session_vars", vars.row),
+		cb.Row().After("gorm:row").Before("wgorm:timeout_after").Register("wgorm:session_vars_end", vars.rowEnd),
+		cb.Query().After("wgorm:tenant").Before("gorm:query").Register("wgorm:sort", sortTieBreaker),
+		cb.Query().After("wgorm:sort").Before("gorm:query").Register("wgorm:paginate", page.paginate),
+		cb.Query().Replace("gorm:query", page.query),
+		cb.Query().After("wgorm:paginate").Before("gorm:query").Register("wgorm:cursor", page.cursor),
+		cb.Query().After("gorm:query").Before("gorm:preload").Register("wgorm:cursor_end", page.cursorEnd),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
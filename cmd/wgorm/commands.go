@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/shoyo10/wgorm"
+	"github.com/shoyo10/wgorm/migrate"
+	"github.com/shoyo10/wgorm/repogen"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	errUsage     = errors.New("usage")
+	errUnhealthy = errors.New("unhealthy")
+)
+
+func runPing(ctx context.Context, gf globalFlags) error {
+	cfg, _, err := loadConfig(gf)
+	if err != nil {
+		return err
+	}
+	h, err := wgorm.Ping(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	failed := false
+	for _, n := range h.Nodes {
+		if n.Healthy {
+			fmt.Fprintf(w, "%s\tok\t%v\n", n.Name, n.Latency)
+			continue
+		}
+		failed = true
+		fmt.Fprintf(w, "%s\tfailed\t%s\n", n.Name, n.Error)
+	}
+	w.Flush()
+	if failed {
+		return errUnhealthy
+	}
+	return nil
+}
+
+func runHealth(ctx context.Context, gf globalFlags) error {
+	cfg, _, err := loadConfig(gf)
+	if err != nil {
+		return err
+	}
+	h, err := wgorm.Ping(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if err := printJSON(h); err != nil {
+		return err
+	}
+	if !h.Healthy {
+		return errUnhealthy
+	}
+	return nil
+}
+
+func runMigrate(ctx context.Context, gf globalFlags, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dir := fs.String("dir", "./databases", "migration `dir`")
+	table := fs.String("table", migrate.DefaultTable, "version `table`")
+	dryRun := fs.Bool("dry-run", false, "print the statements instead of running them")
+	if len(args) == 0 {
+		return errUsage
+	}
+	sub := args[0]
+	// migrate to takes the version, the other subcommands no argument
+	positional := parseArgs(fs, args[1:])
+	want := 0
+	if sub == "to" {
+		want = 1
+	}
+	if len(positional) != want {
+		return errUsage
+	}
+
+	cfg, _, err := loadConfig(gf)
+	if err != nil {
+		return err
+	}
+	db, err := wgorm.OpenMasterDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	opts := []migrate.Option{migrate.WithTable(*table), migrate.WithOutput(os.Stdout)}
+	if *dryRun {
+		opts = append(opts, migrate.WithDryRun())
+	}
+	m := migrate.New(db, migrate.Dir(*dir), opts...)
+
+	switch sub {
+	case "up":
+		return m.Up(ctx)
+	case "down":
+		return m.Down(ctx)
+	case "to":
+		version, err := strconv.ParseInt(positional[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q", positional[0])
+		}
+		return m.To(ctx, version)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "Applied At\tMigration")
+		for _, s := range statuses {
+			at := "Pending"
+			if s.AppliedAt != nil {
+				at = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%s\t%s\n", at, s.Name)
+		}
+		return w.Flush()
+	}
+	return errUsage
+}
+
+func runConfig(gf globalFlags, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	redacted := fs.Bool("redacted", false, "mask passwords and private keys")
+	sources := fs.Bool("sources", false, "print where every value came from instead")
+	sub := args[0]
+	if len(parseArgs(fs, args[1:])) != 0 {
+		return errUsage
+	}
+
+	cfg, src, err := loadConfig(gf)
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "validate":
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		fmt.Println("config is valid")
+		return nil
+	case "print":
+		if *sources {
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, p := range src.Paths() {
+				fmt.Fprintf(w, "%s\t%s\n", p, src[p])
+			}
+			return w.Flush()
+		}
+		if *redacted {
+			cfg = cfg.Redacted()
+		}
+		b, err := yaml.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return errUsage
+}
+
+func runReplicaLag(ctx context.Context, gf globalFlags) error {
+	cfg, _, err := loadConfig(gf)
+	if err != nil {
+		return err
+	}
+	lags, err := wgorm.ReplicaLagOf(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	failed := false
+	for _, l := range lags {
+		switch {
+		case l.Error != "":
+			failed = true
+			fmt.Fprintf(w, "%s\tfailed\t%s\n", l.Name, l.Error)
+		case !l.InRecovery:
+			fmt.Fprintf(w, "%s\tnot replicating\t\n", l.Name)
+		default:
+			fmt.Fprintf(w, "%s\t%v\t\n", l.Name, l.Lag)
+		}
+	}
+	w.Flush()
+	if failed {
+		return errUnhealthy
+	}
+	return nil
+}
+
+func runGenRepo(ctx context.Context, gf globalFlags, args []string) error {
+	fs := flag.NewFlagSet("gen repo", flag.ExitOnError)
+	out := fs.String("out", ".", "output `dir` holding the model and repository packages")
+	modelImport := fs.String("model-import", "", "import `path` of the model package, e.g. github.com/you/app/internal/model")
+	repoPackage := fs.String("repo-package", "repository", "repository package `name`")
+	schema := fs.String("schema", "", "`schema` of the tables, the current one by default")
+	tables := fs.String("tables", "", "comma separated `tables`, every table by default")
+	if len(parseArgs(fs, args)) != 0 || *modelImport == "" {
+		return errUsage
+	}
+
+	cfg, _, err := loadConfig(gf)
+	if err != nil {
+		return err
+	}
+	db, err := wgorm.OpenMasterDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var names []string
+	if *tables != "" {
+		names = strings.Split(*tables, ",")
+	}
+	entities, err := repogen.FromTables(ctx, db, *schema, names...)
+	if err != nil {
+		return err
+	}
+	files, err := repogen.Files(entities, *modelImport, repogen.WithRepoPackage(*repoPackage))
+	if err != nil {
+		return err
+	}
+	if err := repogen.Write(*out, files); err != nil {
+		return err
+	}
+	for _, f := range files {
+		fmt.Println(f.Path)
+	}
+	return nil
+}
+
+// parseArgs parses the flags of args wherever they are and returns the other arguments,
+// flag alone stops at the first one so flags after a positional argument would be dropped
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
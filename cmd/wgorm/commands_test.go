@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args       []string
+		positional []string
+		dryRun     bool
+		dir        string
+	}{
+		{args: []string{"20210601"}, positional: []string{"20210601"}, dir: "./databases"},
+		{args: []string{"-dry-run", "20210601"}, positional: []string{"20210601"}, dryRun: true, dir: "./databases"},
+		{args: []string{"20210601", "-dry-run", "-dir", "db"}, positional: []string{"20210601"}, dryRun: true, dir: "db"},
+		{args: []string{"20210601", "extra", "-dry-run"}, positional: []string{"20210601", "extra"}, dryRun: true, dir: "./databases"},
+		{args: []string{"-dir", "db", "--", "-dry-run"}, positional: []string{"-dry-run"}, dir: "db"},
+		{args: nil, dir: "./databases"},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+		dryRun := fs.Bool("dry-run", false, "")
+		dir := fs.String("dir", "./databases", "")
+		positional := parseArgs(fs, tt.args)
+		if !reflect.DeepEqual(positional, tt.positional) || *dryRun != tt.dryRun || *dir != tt.dir {
+			t.Errorf("parseArgs(%q) = %q with dry-run %v and dir %s, want %q with %v and %s",
+				tt.args, positional, *dryRun, *dir, tt.positional, tt.dryRun, tt.dir)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Command wgorm runs ops tasks against the databases of a wgorm config,
+// reading the same yaml the service does
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shoyo10/wgorm"
+)
+
+const usage = `usage: wgorm [flags] <command> [args]
+
+commands:
+  ping                          ping the master and every slave
+  health                        print the health of the master and every slave as json
+  migrate up|down|status|to V   run the goose migrations of -dir
+  config validate               check the config
+  config print [-redacted]      print the config with the defaults applied
+  replicas lag                  print the replication lag of every slave
+  gen repo -model-import P      generate the model and repository of tables
+
+flags:
+`
+
+type globalFlags struct {
+	config    string
+	key       string
+	envPrefix string
+	timeout   time.Duration
+}
+
+func main() {
+	var gf globalFlags
+	fs := flag.NewFlagSet("wgorm", flag.ExitOnError)
+	fs.StringVar(&gf.config, "config", "config.yaml", "yaml config `file`")
+	fs.StringVar(&gf.key, "key", "database", "`key` the database config is nested under, empty for the file root")
+	fs.StringVar(&gf.envPrefix, "env-prefix", "", "environment variable `prefix` overlaying the file, e.g. DB")
+	fs.DurationVar(&gf.timeout, "timeout", 30*time.Second, "timeout of the command")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gf.timeout)
+	err := run(ctx, gf, fs.Args())
+	cancel()
+	if err != nil {
+		if err == errUsage {
+			fs.Usage()
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "wgorm: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, gf globalFlags, args []string) error {
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "ping":
+		return runPing(ctx, gf)
+	case "health":
+		return runHealth(ctx, gf)
+	case "migrate":
+		return runMigrate(ctx, gf, args)
+	case "config":
+		return runConfig(gf, args)
+	case "replicas":
+		if len(args) != 1 || args[0] != "lag" {
+			return errUsage
+		}
+		return runReplicaLag(ctx, gf)
+	case "gen":
+		if len(args) == 0 || args[0] != "repo" {
+			return errUsage
+		}
+		return runGenRepo(ctx, gf, args[1:])
+	}
+	return errUsage
+}
+
+func loadConfig(gf globalFlags) (*wgorm.Config, wgorm.Sources, error) {
+	return wgorm.LoadConfig(wgorm.LoadOptions{
+		File:      gf.config,
+		Key:       gf.key,
+		EnvPrefix: gf.envPrefix,
+	})
+}
@@ -1,17 +1,22 @@
 package wgorm
 
 import (
+	"context"
+	"crypto/tls"
+	"database/sql"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/shoyo10/wgorm/logger"
 
 	"github.com/cenk/backoff"
+	"github.com/jackc/pgx/v4"
 	"github.com/pkg/errors"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/plugin/dbresolver"
+	gormLogger "gorm.io/gorm/logger"
 )
 
 // DatabaseDriver 類型
@@ -29,11 +34,47 @@ type ConnConfig struct {
 	Password string `yaml:"password" mapstructure:"password"`
 	DBName   string `yaml:"dbname" mapstructure:"dbname"`
 
+	// DSN is a keyword/value connection string or a postgres:// url,
+	// the fields above take precedence over the settings it holds
+	DSN string `yaml:"dsn" mapstructure:"dsn"`
+
+	// PasswordFile and PasswordEnv read the password from a file or an environment variable
+	// before each new connection, SecretProvider and PasswordFunc take precedence over both
+	PasswordFile   string         `yaml:"password_file" mapstructure:"password_file"`
+	PasswordEnv    string         `yaml:"password_env" mapstructure:"password_env"`
+	SecretProvider SecretProvider `yaml:"-" mapstructure:"-"`
+
+	// PasswordFunc returns a short-lived password such as an auth token, it is invoked before new
+	// connections are opened and its result is cached for PasswordTTLSec (default 300), then refreshed
+	// in the background PasswordRefreshBeforeSec (default a fifth of the ttl) ahead of expiry
+	PasswordFunc             func(ctx context.Context) (string, error) `yaml:"-" mapstructure:"-"`
+	PasswordTTLSec           int                                       `yaml:"password_ttl_sec" mapstructure:"password_ttl_sec"`
+	PasswordRefreshBeforeSec int                                       `yaml:"password_refresh_before_sec" mapstructure:"password_refresh_before_sec"`
+
 	// for postgresql
 	SearchPath string `yaml:"search_path" mapstructure:"search_path"`
 	SSLEnable  bool   `yaml:"ssl_enable" mapstructure:"ssl_enable"`
 
-	dsn string
+	// SSLMode is one of the libpq sslmode values, takes precedence over SSLEnable
+	SSLMode SSLMode `yaml:"ssl_mode" mapstructure:"ssl_mode"`
+	// SSLRootCert, SSLCert and SSLKey hold either a file path or the PEM content itself
+	SSLRootCert   string `yaml:"ssl_root_cert" mapstructure:"ssl_root_cert"`
+	SSLCert       string `yaml:"ssl_cert" mapstructure:"ssl_cert"`
+	SSLKey        string `yaml:"ssl_key" mapstructure:"ssl_key"`
+	SSLServerName string `yaml:"ssl_server_name" mapstructure:"ssl_server_name"`
+
+	// postgresql session parameters, 0 or empty leaves the server default
+	ApplicationName                   string `yaml:"application_name" mapstructure:"application_name"`
+	ConnectTimeoutSec                 int    `yaml:"connect_timeout_sec" mapstructure:"connect_timeout_sec"`
+	StatementTimeoutMs                int    `yaml:"statement_timeout_ms" mapstructure:"statement_timeout_ms"`
+	LockTimeoutMs                     int    `yaml:"lock_timeout_ms" mapstructure:"lock_timeout_ms"`
+	IdleInTransactionSessionTimeoutMs int    `yaml:"idle_in_transaction_session_timeout_ms" mapstructure:"idle_in_transaction_session_timeout_ms"`
+	Timezone                          string `yaml:"timezone" mapstructure:"timezone"`
+	// RuntimeParams are extra run-time parameters sent when the connection starts
+	RuntimeParams map[string]string `yaml:"runtime_params" mapstructure:"runtime_params"`
+
+	connString string
+	tlsConfig  *tls.Config
 }
 
 type Config struct {
@@ -41,9 +82,30 @@ type Config struct {
 	MaxIdleConns       int            `yaml:"max_idle_conns" mapstructure:"max_idle_conns"`
 	MaxOpenConns       int            `yaml:"max_open_conns" mapstructure:"max_open_conns"`
 	ConnMaxLifeTimeSec int            `yaml:"conn_max_life_time_sec" mapstructure:"conn_max_life_time_sec"`
-	Master             ConnConfig     `yaml:"master" mapstructure:"master"`
-	Slave              []ConnConfig   `yaml:"slave" mapstructure:"slave"`
-	Log                logger.Config  `yaml:"log" mapstructure:"log"`
+	// ConnMaxIdleTimeSec closes connections idle for longer, 0 keeps them
+	ConnMaxIdleTimeSec int `yaml:"conn_max_idle_time_sec" mapstructure:"conn_max_idle_time_sec"`
+	// ConnMaxLifeTimeJitterSec retires each connection up to this much before conn_max_life_time_sec,
+	// so connections opened together are not all recycled at once
+	ConnMaxLifeTimeJitterSec int `yaml:"conn_max_life_time_jitter_sec" mapstructure:"conn_max_life_time_jitter_sec"`
+	// MinWarmConns connections are opened at startup and kept open after recycling, on master and every slave
+	MinWarmConns int           `yaml:"min_warm_conns" mapstructure:"min_warm_conns"`
+	Master       ConnConfig    `yaml:"master" mapstructure:"master"`
+	Slave        []ConnConfig  `yaml:"slave" mapstructure:"slave"`
+	Log          logger.Config `yaml:"log" mapstructure:"log"`
+
+	// DefaultQueryTimeoutMs bounds statements whose context has no deadline, 0 means no timeout
+	DefaultQueryTimeoutMs int `yaml:"default_query_timeout_ms" mapstructure:"default_query_timeout_ms"`
+
+	// TenantSchemaPrefix followed by the tenant id of WithTenant is the schema of the tenant,
+	// TenantResolver replaces this mapping when set. Schema routing is off when neither is set.
+	TenantSchemaPrefix string         `yaml:"tenant_schema_prefix" mapstructure:"tenant_schema_prefix"`
+	TenantResolver     TenantResolver `yaml:"-" mapstructure:"-"`
+	// TenantSessionVar is the setting the tenant of WithTenant is stored in for row level security
+	// policies, e.g. app.tenant_id, it is set for every transaction and empty turns it off
+	TenantSessionVar string `yaml:"tenant_session_var" mapstructure:"tenant_session_var"`
+
+	// CursorSecret signs the tokens of Cursor pagination, tokens signed with another secret are rejected
+	CursorSecret string `yaml:"cursor_secret" mapstructure:"cursor_secret"`
 }
 
 func (cfg *Config) clone() (*Config, error) {
@@ -51,29 +113,26 @@ func (cfg *Config) clone() (*Config, error) {
 	switch cfg.Driver {
 	case Postgres:
 		config = *cfg
+		config.Master = cfg.Master.clone()
+		config.Slave = make([]ConnConfig, 0, len(cfg.Slave))
+		for _, cc := range cfg.Slave {
+			config.Slave = append(config.Slave, cc.clone())
+		}
 	default:
 		return nil, errors.WithStack(fmt.Errorf("not support driver:%s", cfg.Driver))
 	}
 	return &config, nil
 }
 
-func (cfg *Config) newGormDB() (*gorm.DB, error) {
-	if err := cfg.setConnectionInfo(); err != nil {
-		return nil, err
-	}
-
-	db, err := cfg.connectMasterDB()
-	if err != nil {
-		return nil, err
-	}
-
-	if len(cfg.Slave) > 0 {
-		if err := cfg.connecSlaveDB(db); err != nil {
-			return nil, err
+func (cc ConnConfig) clone() ConnConfig {
+	if cc.RuntimeParams != nil {
+		params := make(map[string]string, len(cc.RuntimeParams))
+		for k, v := range cc.RuntimeParams {
+			params[k] = v
 		}
+		cc.RuntimeParams = params
 	}
-
-	return db, nil
+	return cc
 }
 
 func (cfg *Config) setConnectionInfo() error {
@@ -81,15 +140,7 @@ func (cfg *Config) setConnectionInfo() error {
 		return err
 	}
 
-	if cfg.MaxIdleConns == 0 {
-		cfg.MaxIdleConns = 50
-	}
-	if cfg.MaxOpenConns == 0 {
-		cfg.MaxOpenConns = 100
-	}
-	if cfg.ConnMaxLifeTimeSec == 0 {
-		cfg.ConnMaxLifeTimeSec = 3600
-	}
+	cfg.setDefaults()
 
 	return nil
 }
@@ -97,9 +148,13 @@ func (cfg *Config) setConnectionInfo() error {
 func (cfg *Config) setDSN() error {
 	switch cfg.Driver {
 	case Postgres:
-		cfg.Master.setPostgresDSN()
+		if err := cfg.Master.setPostgresDSN(); err != nil {
+			return errors.WithMessage(err, "master")
+		}
 		for i, cc := range cfg.Slave {
-			cc.setPostgresDSN()
+			if err := cc.setPostgresDSN(); err != nil {
+				return errors.WithMessagef(err, "slave[%d]", i)
+			}
 			cfg.Slave[i] = cc
 		}
 	default:
@@ -109,24 +164,101 @@ func (cfg *Config) setDSN() error {
 	return nil
 }
 
-func (cc *ConnConfig) setPostgresDSN() {
-	dsn := fmt.Sprintf(`user=%s password=%s host=%s port=%d dbname=%s`, cc.Username, cc.Password, cc.Host, cc.Port, cc.DBName)
-	if cc.SSLEnable {
-		dsn += " sslmode=require"
-	} else {
-		dsn += " sslmode=disable"
+func (cc *ConnConfig) setPostgresDSN() error {
+	if err := cc.mergeDSN(); err != nil {
+		return err
 	}
+
+	tlsConfig, err := cc.buildTLSConfig()
+	if err != nil {
+		return err
+	}
+	cc.tlsConfig = tlsConfig
+
+	params := make(map[string]string, len(cc.RuntimeParams)+16)
+	for k, v := range cc.RuntimeParams {
+		params[k] = v
+	}
+	for k, v := range map[string]string{
+		"user":     cc.Username,
+		"password": cc.Password,
+		"host":     cc.Host,
+		"dbname":   cc.DBName,
+	} {
+		if v != "" {
+			params[k] = v
+		}
+	}
+	if cc.Port > 0 {
+		params["port"] = strconv.Itoa(int(cc.Port))
+	}
+	params["sslmode"] = string(cc.sslMode())
 	if strings.TrimSpace(cc.SearchPath) != "" {
-		dsn = fmt.Sprintf("%s search_path=%s", dsn, cc.SearchPath)
+		params["search_path"] = cc.SearchPath
+	}
+	if cc.ApplicationName != "" {
+		params["application_name"] = cc.ApplicationName
+	}
+	if cc.ConnectTimeoutSec > 0 {
+		params["connect_timeout"] = strconv.Itoa(cc.ConnectTimeoutSec)
+	}
+	if cc.StatementTimeoutMs > 0 {
+		params["statement_timeout"] = strconv.Itoa(cc.StatementTimeoutMs)
+	}
+	if cc.LockTimeoutMs > 0 {
+		params["lock_timeout"] = strconv.Itoa(cc.LockTimeoutMs)
+	}
+	if cc.IdleInTransactionSessionTimeoutMs > 0 {
+		params["idle_in_transaction_session_timeout"] = strconv.Itoa(cc.IdleInTransactionSessionTimeoutMs)
+	}
+	if cc.Timezone != "" {
+		params["timezone"] = cc.Timezone
+	}
+
+	cc.connString = buildDSN(params)
+	return nil
+}
+
+// openSQLDB creates the connection pool of one database, it does not connect yet
+func (cfg *Config) openSQLDB(cc ConnConfig, settings *poolSettings) (*sql.DB, error) {
+	switch cfg.Driver {
+	case Postgres:
+		pgxConfig, err := pgx.ParseConfig(cc.connString)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		// pgx builds its tls config from files only, replace it with ours on every attempt that uses tls
+		if cc.tlsConfig != nil {
+			if pgxConfig.TLSConfig != nil {
+				pgxConfig.TLSConfig = cc.tlsConfig.Clone()
+			}
+			for _, fallback := range pgxConfig.Fallbacks {
+				if fallback.TLSConfig != nil {
+					fallback.TLSConfig = cc.tlsConfig.Clone()
+				}
+			}
+		}
+
+		password := cc.secretProvider()
+		if password != nil {
+			// resolve once so a missing secret fails here instead of after the connect backoff
+			if pgxConfig.Password, err = password.Password(context.Background()); err != nil {
+				return nil, err
+			}
+		}
+		return sql.OpenDB(newConnector(pgxConfig, password, settings)), nil
+	default:
+		return nil, errors.WithStack(fmt.Errorf("not support driver:%s", cfg.Driver))
 	}
-	cc.dsn = dsn
 }
 
-func (cfg *Config) getDialector(dsn string) (gorm.Dialector, error) {
+func (cfg *Config) getDialector(sqlDB *sql.DB) (gorm.Dialector, error) {
 	var dialector gorm.Dialector
 	switch cfg.Driver {
 	case Postgres:
-		dialector = postgres.Open(dsn)
+		dialector = postgres.New(postgres.Config{
+			Conn: sqlDB,
+		})
 	default:
 		return nil, errors.WithStack(fmt.Errorf("not support driver:%s", cfg.Driver))
 	}
@@ -134,14 +266,19 @@ func (cfg *Config) getDialector(dsn string) (gorm.Dialector, error) {
 	return dialector, nil
 }
 
-func (cfg *Config) connectMasterDB() (*gorm.DB, error) {
-	dialector, err := cfg.getDialector(cfg.Master.dsn)
+func (cfg *Config) setPool(sqlDB *sql.DB) {
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifeTimeSec) * time.Second)
+	sqlDB.SetConnMaxIdleTime(time.Duration(cfg.ConnMaxIdleTimeSec) * time.Second)
+}
+
+func (cfg *Config) connectMasterDB(sqlDB *sql.DB, newLogger gormLogger.Interface) (*gorm.DB, error) {
+	dialector, err := cfg.getDialector(sqlDB)
 	if err != nil {
 		return nil, err
 	}
 
-	newLogger := logger.New(cfg.Log)
-
 	bo := backoff.NewExponentialBackOff()
 	bo.MaxElapsedTime = time.Duration(180) * time.Second
 	var db *gorm.DB
@@ -157,11 +294,6 @@ func (cfg *Config) connectMasterDB() (*gorm.DB, error) {
 			return err
 		}
 
-		sqlDB, err := db.DB()
-		if err != nil {
-			return err
-		}
-
 		err = sqlDB.Ping()
 		return err
 	}, bo)
@@ -170,37 +302,25 @@ func (cfg *Config) connectMasterDB() (*gorm.DB, error) {
 		return nil, errors.WithStack(fmt.Errorf("connect db failed: %v", err))
 	}
 
-	sqlDB, err := db.DB()
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
-	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
-	sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifeTimeSec) * time.Second)
+	cfg.setPool(sqlDB)
 
 	return db, nil
 }
 
-func (cfg *Config) connecSlaveDB(db *gorm.DB) error {
-	var dialectors []gorm.Dialector
-	for _, cc := range cfg.Slave {
-		d, err := cfg.getDialector(cc.dsn)
-		if err != nil {
-			return err
-		}
-		dialectors = append(dialectors, d)
-	}
-	err := db.Use(
-		dbresolver.Register(dbresolver.Config{
-			Replicas: dialectors,
-		}).
-			SetMaxIdleConns(cfg.MaxIdleConns).
-			SetMaxOpenConns(cfg.MaxOpenConns).
-			SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifeTimeSec) * time.Second))
+// connectSlaveDB opens and checks one replica
+func (cfg *Config) connectSlaveDB(cc ConnConfig, settings *poolSettings) (*replica, error) {
+	sqlDB, err := cfg.openSQLDB(cc, settings)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, err
 	}
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
+		return nil, errors.WithStack(fmt.Errorf("connect slave db failed: %v", err))
+	}
+	cfg.setPool(sqlDB)
 
-	return nil
+	return &replica{
+		key: cc.connString,
+		db:  sqlDB,
+	}, nil
 }
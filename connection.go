@@ -0,0 +1,184 @@
+package wgorm
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/shoyo10/wgorm/logger"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
+)
+
+// connection is shared by every Gorm derived from the one returned by New
+type connection struct {
+	db       *gorm.DB
+	master   *sql.DB
+	replicas *replicaPool
+	logger   gormLogger.Interface
+	pool     *poolSettings
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	reloadMu sync.Mutex
+	mu       sync.RWMutex
+	cfg      *Config
+}
+
+func newConnection(cfg *Config) (*connection, error) {
+	if err := cfg.setConnectionInfo(); err != nil {
+		return nil, err
+	}
+
+	pool := newPoolSettings(cfg)
+	master, err := cfg.openSQLDB(cfg.Master, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &connection{
+		master: master,
+		logger: logger.New(cfg.Log),
+		pool:   pool,
+		stop:   make(chan struct{}),
+		cfg:    cfg,
+	}
+
+	db, err := cfg.connectMasterDB(master, conn.logger)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	conn.db = db
+
+	if err := registerCallbacks(db, conn); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	var replicas []*replica
+	for i, cc := range cfg.Slave {
+		r, err := cfg.connectSlaveDB(cc, conn.pool)
+		if err != nil {
+			closeReplicas(replicas)
+			master.Close()
+			return nil, errors.WithMessagef(err, "slave[%d]", i)
+		}
+		replicas = append(replicas, r)
+	}
+	conn.replicas = newReplicaPool(master, replicas)
+
+	dialector, err := cfg.getDialector(master)
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+	err = db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{replicaDialector{Dialector: dialector, pool: conn.replicas}},
+	}))
+	if err != nil {
+		conn.close()
+		return nil, errors.WithStack(err)
+	}
+
+	// warm up before returning so the first requests find open connections
+	conn.warmAll()
+	go conn.keepWarm(conn.stop)
+
+	return conn, nil
+}
+
+func (conn *connection) config() *Config {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	return conn.cfg
+}
+
+// reload applies cfg to the open connection: pool settings in place, replicas added or removed,
+// logger settings swapped. Removed replicas are closed once their running queries finish.
+func (conn *connection) reload(cfg *Config) error {
+	conn.reloadMu.Lock()
+	defer conn.reloadMu.Unlock()
+
+	if err := cfg.setConnectionInfo(); err != nil {
+		return err
+	}
+
+	old := conn.config()
+	if cfg.Driver != old.Driver || cfg.Master.connString != old.Master.connString {
+		return errors.WithStack(fmt.Errorf("master connection changed, it can not be reloaded without restart"))
+	}
+
+	existing := make(map[string][]*replica)
+	for _, r := range conn.replicas.list() {
+		existing[r.key] = append(existing[r.key], r)
+	}
+
+	var next, opened []*replica
+	for i, cc := range cfg.Slave {
+		if rs := existing[cc.connString]; len(rs) > 0 {
+			next = append(next, rs[0])
+			existing[cc.connString] = rs[1:]
+			continue
+		}
+		r, err := cfg.connectSlaveDB(cc, conn.pool)
+		if err != nil {
+			closeReplicas(opened)
+			return errors.WithMessagef(err, "slave[%d]", i)
+		}
+		opened = append(opened, r)
+		next = append(next, r)
+	}
+
+	conn.pool.set(cfg)
+	cfg.setPool(conn.master)
+	for _, r := range next {
+		cfg.setPool(r.db)
+	}
+	conn.replicas.swap(next)
+	for _, rs := range existing {
+		for _, r := range rs {
+			go r.close()
+		}
+	}
+
+	if l, ok := conn.logger.(logger.Reloadable); ok {
+		l.Reload(cfg.Log)
+	}
+
+	conn.mu.Lock()
+	conn.cfg = cfg
+	conn.mu.Unlock()
+	// top up new slaves and a raised min_warm_conns right away
+	go conn.warmAll()
+
+	return nil
+}
+
+func (conn *connection) close() error {
+	conn.stopOnce.Do(func() { close(conn.stop) })
+
+	var errs []string
+	if conn.replicas != nil {
+		for _, r := range conn.replicas.list() {
+			if err := r.close(); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if err := conn.master.Close(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.WithStack(fmt.Errorf("close db failed: %v", errs))
+	}
+	return nil
+}
+
+func closeReplicas(replicas []*replica) {
+	for _, r := range replicas {
+		r.db.Close()
+	}
+}
@@ -0,0 +1,114 @@
+package wgorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/pkg/errors"
+)
+
+// connector opens pgx connections, resolving the password right before each one when a SecretProvider is set
+type connector struct {
+	password SecretProvider
+	settings *poolSettings
+
+	mu     sync.RWMutex
+	config *pgx.ConnConfig
+}
+
+func newConnector(config *pgx.ConnConfig, password SecretProvider, settings *poolSettings) *connector {
+	return &connector{
+		password: password,
+		settings: settings,
+		config:   config,
+	}
+}
+
+// Connect implements driver.Connector
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if c.password != nil {
+		password, err := c.password.Password(ctx)
+		if err != nil {
+			return nil, errors.WithMessage(err, "resolve database password")
+		}
+		c.setPassword(password)
+	}
+
+	c.mu.RLock()
+	config := c.config.Copy()
+	c.mu.RUnlock()
+	dc, err := connectRegistered(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pooledConn{
+		Conn:     dc.(*stdlib.Conn),
+		settings: c.settings,
+		created:  time.Now(),
+		factor:   jitterFactor(),
+	}, nil
+}
+
+// registryMu serializes wgorm's registrations with the pgx driver
+var registryMu sync.Mutex
+
+// connectRegistered connects with config through the pgx driver, which only takes a config by its
+// registered name. The config, password included, is unregistered as soon as pgx has read it back,
+// at the latest when the connect returns. pgx names a registration after the size of its registry,
+// so registrations must not overlap: removing one while another is alive would hand its name out again.
+func connectRegistered(ctx context.Context, config *pgx.ConnConfig) (driver.Conn, error) {
+	registryMu.Lock()
+	var (
+		name string
+		once sync.Once
+	)
+	release := func() {
+		once.Do(func() {
+			stdlib.UnregisterConnConfig(name)
+			registryMu.Unlock()
+		})
+	}
+	defer release()
+
+	// pgx resolves or dials the host only after reading the config from the registry
+	lookup, dial := config.LookupFunc, config.DialFunc
+	config.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+		release()
+		return lookup(ctx, host)
+	}
+	config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		release()
+		return dial(ctx, network, addr)
+	}
+
+	name = stdlib.RegisterConnConfig(config)
+	inner, err := stdlib.GetDefaultDriver().(driver.DriverContext).OpenConnector(name)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return inner.Connect(ctx)
+}
+
+// Driver implements driver.Connector
+func (c *connector) Driver() driver.Driver {
+	return stdlib.GetDefaultDriver()
+}
+
+func (c *connector) setPassword(password string) {
+	c.mu.RLock()
+	same := c.config.Password == password
+	c.mu.RUnlock()
+	if same {
+		return
+	}
+
+	c.mu.Lock()
+	c.config.Password = password
+	c.mu.Unlock()
+}
@@ -0,0 +1,220 @@
+package wgorm
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dsnKeyOrder keeps the well known keywords first so built dsn are easy to read
+var dsnKeyOrder = []string{"user", "password", "host", "port", "dbname", "sslmode"}
+
+// parseDSN parses a libpq keyword/value connection string or a postgres:// url into its settings
+func parseDSN(dsn string) (map[string]string, error) {
+	dsn = strings.TrimSpace(dsn)
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return parseURLDSN(dsn)
+	}
+	return parseKeywordDSN(dsn)
+}
+
+func parseURLDSN(dsn string) (map[string]string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fieldErrorf("dsn", "parse url: %v", err)
+	}
+
+	params := make(map[string]string)
+	if u.User != nil {
+		params["user"] = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			params["password"] = password
+		}
+	}
+	if host := u.Hostname(); host != "" {
+		params["host"] = host
+	}
+	if port := u.Port(); port != "" {
+		params["port"] = port
+	}
+	if dbname := strings.TrimPrefix(u.Path, "/"); dbname != "" {
+		params["dbname"] = dbname
+	}
+	for k, v := range u.Query() {
+		if len(v) > 0 {
+			params[k] = v[len(v)-1]
+		}
+	}
+
+	return params, nil
+}
+
+// parseKeywordDSN follows libpq's rules: values may be single quoted, backslash escapes the next character
+func parseKeywordDSN(dsn string) (map[string]string, error) {
+	params := make(map[string]string)
+	s := dsn
+	for {
+		s = strings.TrimLeft(s, " \t\n\r")
+		if s == "" {
+			return params, nil
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fieldErrorf("dsn", "missing \"=\" after %q", s)
+		}
+		key := strings.TrimSpace(s[:eq])
+		if key == "" || strings.ContainsAny(key, " \t\n\r") {
+			return nil, fieldErrorf("dsn", "invalid keyword %q", key)
+		}
+		s = strings.TrimLeft(s[eq+1:], " \t\n\r")
+
+		var value strings.Builder
+		if strings.HasPrefix(s, "'") {
+			s = s[1:]
+			closed := false
+			for len(s) > 0 {
+				c := s[0]
+				s = s[1:]
+				if c == '\\' && len(s) > 0 {
+					value.WriteByte(s[0])
+					s = s[1:]
+					continue
+				}
+				if c == '\'' {
+					closed = true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return nil, fieldErrorf("dsn", "unterminated quoted value for %q", key)
+			}
+		} else {
+			for len(s) > 0 && !strings.ContainsRune(" \t\n\r", rune(s[0])) {
+				c := s[0]
+				s = s[1:]
+				if c == '\\' && len(s) > 0 {
+					c = s[0]
+					s = s[1:]
+				}
+				value.WriteByte(c)
+			}
+		}
+		params[key] = value.String()
+	}
+}
+
+// quoteDSNValue quotes a keyword value when it is empty or holds spaces, quotes or backslashes
+func quoteDSNValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\n\r'\\") {
+		return v
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+// buildDSN renders settings as a keyword/value connection string
+func buildDSN(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		known := false
+		for _, o := range dsnKeyOrder {
+			if k == o {
+				known = true
+				break
+			}
+		}
+		if !known {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(params))
+	for _, k := range append(append([]string{}, dsnKeyOrder...), keys...) {
+		v, ok := params[k]
+		if !ok {
+			continue
+		}
+		parts = append(parts, k+"="+quoteDSNValue(v))
+	}
+	return strings.Join(parts, " ")
+}
+
+// mergeDSN fills the structured fields left empty from DSN, the structured fields win on conflict.
+// Settings without a matching field are kept as run-time parameters.
+func (cc *ConnConfig) mergeDSN() error {
+	if strings.TrimSpace(cc.DSN) == "" {
+		return nil
+	}
+	params, err := parseDSN(cc.DSN)
+	if err != nil {
+		return err
+	}
+
+	takeString := func(key string, dst *string) {
+		if v, ok := params[key]; ok {
+			if *dst == "" {
+				*dst = v
+			}
+			delete(params, key)
+		}
+	}
+	takeInt := func(key string, dst *int) {
+		if v, ok := params[key]; ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				// keep values with units such as 5s as they are
+				return
+			}
+			if *dst == 0 {
+				*dst = n
+			}
+			delete(params, key)
+		}
+	}
+
+	takeString("host", &cc.Host)
+	takeString("user", &cc.Username)
+	takeString("password", &cc.Password)
+	takeString("dbname", &cc.DBName)
+	takeString("search_path", &cc.SearchPath)
+	takeString("application_name", &cc.ApplicationName)
+	takeString("timezone", &cc.Timezone)
+	takeString("sslrootcert", &cc.SSLRootCert)
+	takeString("sslcert", &cc.SSLCert)
+	takeString("sslkey", &cc.SSLKey)
+	takeInt("connect_timeout", &cc.ConnectTimeoutSec)
+	takeInt("statement_timeout", &cc.StatementTimeoutMs)
+	takeInt("lock_timeout", &cc.LockTimeoutMs)
+	takeInt("idle_in_transaction_session_timeout", &cc.IdleInTransactionSessionTimeoutMs)
+
+	if v, ok := params["port"]; ok {
+		if port, err := strconv.ParseInt(v, 10, 32); err == nil {
+			if cc.Port == 0 {
+				cc.Port = int32(port)
+			}
+			delete(params, "port")
+		}
+	}
+	if v, ok := params["sslmode"]; ok {
+		if cc.SSLMode == "" && !cc.SSLEnable {
+			cc.SSLMode = SSLMode(v)
+		}
+		delete(params, "sslmode")
+	}
+
+	runtimeParams := make(map[string]string, len(params)+len(cc.RuntimeParams))
+	for k, v := range params {
+		runtimeParams[k] = v
+	}
+	for k, v := range cc.RuntimeParams {
+		runtimeParams[k] = v
+	}
+	cc.RuntimeParams = runtimeParams
+
+	return nil
+}
@@ -6,12 +6,10 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/shoyo10/wgorm"
 	"github.com/shoyo10/wzerolog"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
@@ -25,25 +23,14 @@ type User struct {
 	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"column:deleted_at"`
 }
 
-type Config struct {
-	Database wgorm.Config `yaml:"database"`
-}
-
 func main() {
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	err := viper.ReadInConfig()
-	if err != nil {
-		panic(fmt.Errorf("Fatal error config file: %s", err))
-	}
-
-	var config Config
-	err = viper.Unmarshal(&config, func(cfg *mapstructure.DecoderConfig) {
-		cfg.TagName = "yaml"
+	config, _, err := wgorm.LoadConfig(wgorm.LoadOptions{
+		File:      "config.yaml",
+		Key:       "database",
+		EnvPrefix: "DB",
 	})
 	if err != nil {
-		panic(fmt.Errorf("Fatal error unmarshal config: %s", err))
+		panic(fmt.Errorf("Fatal error load config: %s", err))
 	}
 
 	wzerolog.Init(wzerolog.Config{
@@ -54,7 +41,7 @@ func main() {
 	ctx := context.Background()
 	ctx = log.Logger.WithContext(ctx)
 
-	g, err := wgorm.New(&config.Database)
+	g, err := wgorm.New(config)
 	if err != nil {
 		log.Ctx(ctx).Error().Msgf("new wgorm failed: %v", err)
 		return
@@ -0,0 +1,331 @@
+package wgorm
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Filter ops of the wgorm struct tag
+const (
+	OpEq       = "eq"
+	OpNe       = "ne"
+	OpGt       = "gt"
+	OpGte      = "gte"
+	OpLt       = "lt"
+	OpLte      = "lte"
+	OpIn       = "in"
+	OpNotIn    = "not in"
+	OpLike     = "like"
+	OpILike    = "ilike"
+	OpNull     = "null"
+	OpBetween  = "between"
+	OpContains = "contains"
+)
+
+var filterColumn = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// filterField is a field of a filter struct with its parsed wgorm tag
+type filterField struct {
+	index    []int
+	name     string
+	column   string
+	op       string
+	keepZero bool
+	// group is and or or for a nested filter struct, empty for a condition
+	group string
+}
+
+// filterFields caches the parsed fields of the filter struct types
+var filterFields sync.Map
+
+// Filter narrows the statement with the conditions of a filter struct, see BuildFilter.
+// An invalid filter struct fails the statement.
+func Filter(filter interface{}) Option {
+	return func(g *Gorm) *Gorm {
+		expr, err := BuildFilter(filter)
+		if err != nil {
+			g = g.clone()
+			g.DB = g.DB.Session(&gorm.Session{})
+			g.DB.AddError(err)
+			return g
+		}
+		if expr == nil {
+			return g
+		}
+		return g.clone().setDB(g.DB.Where(expr))
+	}
+}
+
+// BuildFilter turns a filter struct into the conditions of a WHERE clause, nil when none applies.
+// Every field is a condition tagged wgorm:"col:age;op:gte", the column defaults to the snake case
+// field name and the op to eq. The ops are eq, ne, gt, gte, lt, lte, like, ilike, in and not in taking
+// a slice, between taking a slice of two, null taking a bool, true for IS NULL and false for IS NOT NULL,
+// and contains, the jsonb @> of a value marshaled to json unless it is a string or []byte.
+//
+// A field is skipped when it is nil or, unless tagged keepzero, the zero value. A pointer applies
+// whatever it points to, use one to filter on a zero value such as age = 0 or active = false.
+// An empty but non nil slice matches nothing with in and everything with not in.
+//
+// A nested struct, or pointer to one, is a group of conditions joined by AND, or by OR when tagged
+// wgorm:"group:or". The fields of an embedded struct are promoted, a nil embedded pointer is skipped.
+// Fields tagged wgorm:"-" and unexported fields other than embedded structs are ignored.
+func BuildFilter(filter interface{}) (clause.Expression, error) {
+	v := reflect.ValueOf(filter)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, errors.WithStack(fmt.Errorf("filter must be a struct, got %T", filter))
+	}
+	return buildFilterGroup(v, "and")
+}
+
+func buildFilterGroup(v reflect.Value, group string) (clause.Expression, error) {
+	fields, err := parseFilterType(v.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	var exprs []clause.Expression
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		if fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Interface {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		} else if f.group == "" && !f.keepZero && fv.IsZero() {
+			continue
+		}
+
+		var expr clause.Expression
+		if f.group != "" {
+			expr, err = buildFilterGroup(fv, f.group)
+		} else {
+			expr, err = f.build(fv)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if expr != nil {
+			exprs = append(exprs, expr)
+		}
+	}
+
+	switch {
+	case len(exprs) == 0:
+		return nil, nil
+	case len(exprs) == 1:
+		return exprs[0], nil
+	case group == "or":
+		return clause.Or(exprs...), nil
+	}
+	return clause.And(exprs...), nil
+}
+
+// build returns the condition of f for its value v
+func (f filterField) build(v reflect.Value) (clause.Expression, error) {
+	col := clause.Column{Name: f.column}
+	value := v.Interface()
+
+	switch f.op {
+	case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpLike, OpILike:
+		if isFilterList(v) {
+			return nil, f.errorf("op %s takes a single value, got %s, use in", f.op, v.Type())
+		}
+	}
+	switch f.op {
+	case OpEq:
+		return clause.Eq{Column: col, Value: value}, nil
+	case OpNe:
+		return clause.Neq{Column: col, Value: value}, nil
+	case OpGt:
+		return clause.Gt{Column: col, Value: value}, nil
+	case OpGte:
+		return clause.Gte{Column: col, Value: value}, nil
+	case OpLt:
+		return clause.Lt{Column: col, Value: value}, nil
+	case OpLte:
+		return clause.Lte{Column: col, Value: value}, nil
+	case OpLike:
+		return clause.Like{Column: col, Value: value}, nil
+	case OpILike:
+		return clause.Expr{SQL: "? ILIKE ?", Vars: []interface{}{col, value}}, nil
+	case OpNull:
+		if v.Kind() != reflect.Bool {
+			return nil, f.errorf("op null takes a bool, got %s", v.Type())
+		}
+		if v.Bool() {
+			return clause.Eq{Column: col, Value: nil}, nil
+		}
+		return clause.Neq{Column: col, Value: nil}, nil
+	case OpContains:
+		switch value.(type) {
+		case string, []byte:
+		default:
+			b, err := json.Marshal(value)
+			if err != nil {
+				return nil, f.errorf("marshal: %v", err)
+			}
+			value = string(b)
+		}
+		return clause.Expr{SQL: "? @> CAST(? AS jsonb)", Vars: []interface{}{col, value}}, nil
+	}
+
+	// the remaining ops take a slice
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, f.errorf("op %s takes a slice, got %s", f.op, v.Type())
+	}
+	values := make([]interface{}, v.Len())
+	for i := range values {
+		values[i] = v.Index(i).Interface()
+	}
+	switch f.op {
+	case OpIn:
+		return clause.IN{Column: col, Values: values}, nil
+	case OpNotIn:
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return clause.Not(clause.IN{Column: col, Values: values}), nil
+	}
+	if len(values) != 2 {
+		return nil, f.errorf("op between takes 2 values, got %d", len(values))
+	}
+	return clause.Expr{SQL: "? BETWEEN ? AND ?", Vars: []interface{}{col, values[0], values[1]}}, nil
+}
+
+// isFilterList reports whether v is a list of values rather than one, []byte and driver.Valuer types are one value
+func isFilterList(v reflect.Value) bool {
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return false
+	}
+	if v.Type().Elem().Kind() == reflect.Uint8 {
+		return false
+	}
+	return !v.Type().Implements(valuerType) && !reflect.PtrTo(v.Type()).Implements(valuerType)
+}
+
+func (f filterField) errorf(format string, args ...interface{}) error {
+	return errors.WithStack(fmt.Errorf("filter field %s: %s", f.name, fmt.Sprintf(format, args...)))
+}
+
+// parseFilterType parses the wgorm tags of a filter struct type
+func parseFilterType(t reflect.Type) ([]filterField, error) {
+	if fields, ok := filterFields.Load(t); ok {
+		return fields.([]filterField), nil
+	}
+
+	var fields []filterField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("wgorm")
+		if tag == "-" {
+			continue
+		}
+		// the fields of an embedded struct are promoted, also when it is unexported
+		if sf.Anonymous && tag == "" && sf.Type.Kind() == reflect.Struct && isFilterGroup(sf.Type) {
+			embedded, err := parseFilterType(sf.Type)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range embedded {
+				f.index = append(append([]int{}, sf.Index...), f.index...)
+				fields = append(fields, f)
+			}
+			continue
+		}
+		if sf.PkgPath != "" && !(sf.Anonymous && tag == "" && isFilterGroup(sf.Type)) {
+			continue
+		}
+		f := filterField{
+			index: sf.Index,
+			name:  sf.Name,
+		}
+		if t.Name() != "" {
+			f.name = t.Name() + "." + sf.Name
+		}
+		for _, part := range strings.Split(tag, ";") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			kv := strings.SplitN(part, ":", 2)
+			key, value := strings.ToLower(strings.TrimSpace(kv[0])), ""
+			if len(kv) == 2 {
+				value = strings.TrimSpace(kv[1])
+			}
+			switch key {
+			case "col":
+				f.column = value
+			case "op":
+				f.op = strings.ToLower(strings.Join(strings.Fields(value), " "))
+			case "group":
+				f.group = strings.ToLower(value)
+			case "keepzero":
+				f.keepZero = true
+			default:
+				return nil, f.errorf("unknown tag %q", part)
+			}
+		}
+
+		if f.group == "" && f.op == "" && f.column == "" && isFilterGroup(sf.Type) {
+			f.group = "and"
+		}
+		if f.group != "" {
+			if f.group != "and" && f.group != "or" {
+				return nil, f.errorf("group must be and or or, got %q", f.group)
+			}
+			if !isFilterGroup(sf.Type) {
+				return nil, f.errorf("group takes a struct, got %s", sf.Type)
+			}
+			fields = append(fields, f)
+			continue
+		}
+
+		if f.column == "" {
+			f.column = schema.NamingStrategy{}.ColumnName("", sf.Name)
+		}
+		if !filterColumn.MatchString(f.column) {
+			return nil, f.errorf("invalid column %q", f.column)
+		}
+		switch f.op {
+		case "":
+			f.op = OpEq
+		case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn, OpNotIn, OpLike, OpILike, OpNull, OpBetween, OpContains:
+		default:
+			return nil, f.errorf("unknown op %q", f.op)
+		}
+		fields = append(fields, f)
+	}
+
+	filterFields.Store(t, fields)
+	return fields, nil
+}
+
+// isFilterGroup reports whether fields of type t hold a nested filter struct rather than a value
+func isFilterGroup(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType && !t.Implements(valuerType) && !reflect.PtrTo(t).Implements(valuerType)
+}
@@ -0,0 +1,245 @@
+package wgorm
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dryRunDB builds statements without a server
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost user=app dbname=db"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type filterUser struct {
+	ID   int64
+	Name string
+}
+
+type filterPaging struct {
+	Status string
+}
+
+type filterAge struct {
+	MinAge int `wgorm:"col:age;op:gte"`
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestBuildFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  interface{}
+		where   string
+		vars    []interface{}
+		wantErr bool
+	}{
+		{
+			name: "default column and op",
+			filter: struct {
+				UserName string
+			}{UserName: "bob"},
+			where: `"user_name" = $1`,
+			vars:  []interface{}{"bob"},
+		},
+		{
+			name: "tags",
+			filter: struct {
+				Age   int      `wgorm:"col:u.age; op: gte"`
+				Name  string   `wgorm:"op:ILIKE"`
+				IDs   []int64  `wgorm:"col:id;op:not  in"`
+				Range []int    `wgorm:"col:score;op:between"`
+				Tags  []string `wgorm:"op:contains"`
+				Meta  string   `wgorm:"op:contains"`
+			}{Age: 18, Name: "b%", IDs: []int64{1, 2}, Range: []int{1, 9}, Tags: []string{"a"}, Meta: `{"a":1}`},
+			where: `("u"."age" >= $1 AND "name" ILIKE $2 AND "id" NOT IN ($3,$4) AND ("score" BETWEEN $5 AND $6) AND "tags" @> CAST($7 AS jsonb) AND "meta" @> CAST($8 AS jsonb))`,
+			vars:  []interface{}{18, "b%", int64(1), int64(2), 1, 9, `["a"]`, `{"a":1}`},
+		},
+		{
+			name: "zero values are skipped",
+			filter: struct {
+				Name   string
+				Age    int
+				Active bool
+				IDs    []int64 `wgorm:"col:id;op:in"`
+			}{},
+			where: "",
+		},
+		{
+			name: "pointers and keepzero apply zero values",
+			filter: struct {
+				Age    *int
+				Active bool `wgorm:"keepzero"`
+				Name   *string
+			}{Age: intPtr(0)},
+			where: `("age" = $1 AND "active" = $2)`,
+			vars:  []interface{}{0, false},
+		},
+		{
+			name: "null",
+			filter: struct {
+				DeletedAt *bool `wgorm:"op:null"`
+				Email     bool  `wgorm:"op:null;keepzero"`
+			}{DeletedAt: new(bool)},
+			where: `("deleted_at" IS NOT NULL AND "email" IS NOT NULL)`,
+		},
+		{
+			name: "empty in matches nothing, empty not in everything",
+			filter: struct {
+				IDs   []int64  `wgorm:"col:id;op:in;keepzero"`
+				Names []string `wgorm:"col:name;op:not in;keepzero"`
+			}{IDs: []int64{}, Names: []string{}},
+			where: `"id" IN (NULL)`,
+		},
+		{
+			name: "or group",
+			filter: struct {
+				Status string
+				Match  struct {
+					Name  string `wgorm:"op:like"`
+					Email string `wgorm:"op:like"`
+				} `wgorm:"group:or"`
+			}{Status: "active", Match: struct {
+				Name  string `wgorm:"op:like"`
+				Email string `wgorm:"op:like"`
+			}{Name: "b%", Email: "b%"}},
+			where: `("status" = $1 AND ("name" LIKE $2 OR "email" LIKE $3))`,
+			vars:  []interface{}{"active", "b%", "b%"},
+		},
+		{
+			name: "nil and empty groups are skipped",
+			filter: struct {
+				Age   *filterAge
+				Other filterAge
+				Name  string
+			}{Name: "bob"},
+			where: `"name" = $1`,
+			vars:  []interface{}{"bob"},
+		},
+		{
+			name: "embedded structs are promoted",
+			filter: struct {
+				filterPaging
+				*filterAge
+				Name string
+			}{filterPaging: filterPaging{Status: "active"}, filterAge: &filterAge{MinAge: 18}, Name: "bob"},
+			where: `("status" = $1 AND "age" >= $2 AND "name" = $3)`,
+			vars:  []interface{}{"active", 18, "bob"},
+		},
+		{
+			name: "ignored fields",
+			filter: struct {
+				Name   string `wgorm:"-"`
+				status string
+			}{Name: "bob", status: "active"},
+			where: "",
+		},
+		{
+			name: "[]byte is one value",
+			filter: struct {
+				Hash []byte
+			}{Hash: []byte("x")},
+			where: `"hash" = $1`,
+			vars:  []interface{}{[]byte("x")},
+		},
+		{
+			name: "scalar op with a slice",
+			filter: struct {
+				IDs []int64 `wgorm:"col:id"`
+			}{IDs: []int64{1, 2}},
+			wantErr: true,
+		},
+		{
+			name: "in without a slice",
+			filter: struct {
+				ID int64 `wgorm:"op:in"`
+			}{ID: 1},
+			wantErr: true,
+		},
+		{
+			name: "between without 2 values",
+			filter: struct {
+				Age []int `wgorm:"op:between"`
+			}{Age: []int{1}},
+			wantErr: true,
+		},
+		{
+			name: "null without a bool",
+			filter: struct {
+				Age int `wgorm:"op:null"`
+			}{Age: 1},
+			wantErr: true,
+		},
+		{
+			name: "unknown op",
+			filter: struct {
+				Age int `wgorm:"op:over"`
+			}{},
+			wantErr: true,
+		},
+		{
+			name: "unknown tag",
+			filter: struct {
+				Age int `wgorm:"column:age"`
+			}{},
+			wantErr: true,
+		},
+		{
+			name: "invalid column",
+			filter: struct {
+				Age int `wgorm:"col:age; DROP TABLE users"`
+			}{},
+			wantErr: true,
+		},
+		{
+			name: "group without a struct",
+			filter: struct {
+				Age int `wgorm:"group:or"`
+			}{},
+			wantErr: true,
+		},
+		{name: "not a struct", filter: "name = 'bob'", wantErr: true},
+	}
+	db := dryRunDB(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := BuildFilter(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			tx := db.Session(&gorm.Session{})
+			if expr != nil {
+				tx = tx.Where(expr)
+			}
+			stmt := tx.Find(&[]filterUser{}).Statement
+			want := `SELECT * FROM "filter_users"`
+			if tt.where != "" {
+				want += " WHERE " + tt.where
+			}
+			if got := stmt.SQL.String(); got != want {
+				t.Errorf("SQL = %s, want %s", got, want)
+			}
+			if len(stmt.Vars) != 0 || len(tt.vars) != 0 {
+				if !reflect.DeepEqual(stmt.Vars, tt.vars) {
+					t.Errorf("vars = %#v, want %#v", stmt.Vars, tt.vars)
+				}
+			}
+		})
+	}
+}
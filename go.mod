@@ -4,11 +4,15 @@ go 1.15
 
 require (
 	github.com/cenk/backoff v2.2.1+incompatible
+	github.com/fsnotify/fsnotify v1.4.7
+	github.com/jackc/pgconn v1.8.1
+	github.com/jackc/pgx/v4 v4.11.0
 	github.com/mitchellh/mapstructure v1.1.2
 	github.com/pkg/errors v0.9.1
 	github.com/rs/zerolog v1.22.0
 	github.com/shoyo10/wzerolog v0.0.0-20210524155643-8c8c5187d85d
 	github.com/spf13/viper v1.7.1
+	gopkg.in/yaml.v2 v2.2.4
 	gorm.io/driver/postgres v1.1.0
 	gorm.io/gorm v1.21.10
 	gorm.io/plugin/dbresolver v1.1.0
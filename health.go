@@ -0,0 +1,226 @@
+package wgorm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const replicaLagQuery = `SELECT pg_is_in_recovery(),
+	COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)::float8`
+
+// NodeHealth is the state of one database of a Gorm, the master or a slave
+type NodeHealth struct {
+	// Name is master or slave[i]
+	Name    string        `json:"name"`
+	Healthy bool          `json:"healthy"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Stats   sql.DBStats   `json:"stats"`
+}
+
+// Health is the state of the master and every slave of a Gorm.
+// It is healthy when the master is, an unhealthy slave only marks it degraded.
+type Health struct {
+	Healthy  bool         `json:"healthy"`
+	Degraded bool         `json:"degraded"`
+	Nodes    []NodeHealth `json:"nodes"`
+}
+
+// ReplicaLag is how far a slave is behind the master
+type ReplicaLag struct {
+	// Name is slave[i]
+	Name string `json:"name"`
+	// InRecovery is false when the slave is not replicating, e.g. after a promotion
+	InRecovery bool `json:"in_recovery"`
+	// Lag is the age of the last replayed transaction, it also grows while the master is idle
+	Lag   time.Duration `json:"lag"`
+	Error string        `json:"error,omitempty"`
+}
+
+// Health pings the master and the slaves concurrently, bound the wait with the deadline of ctx
+func (g *Gorm) Health(ctx context.Context) Health {
+	if g.cluster != nil {
+		return newHealth([]NodeHealth{{Name: "master", Error: ErrShardKeyRequired.Error()}})
+	}
+	names, dbs := g.conn.nodes()
+	nodes := make([]NodeHealth, len(dbs))
+	eachNode(dbs, func(i int, db *sql.DB) {
+		nodes[i] = pingNode(ctx, names[i], db)
+	})
+	return newHealth(nodes)
+}
+
+// ReplicaLag measures the replication lag of every slave concurrently
+func (g *Gorm) ReplicaLag(ctx context.Context) []ReplicaLag {
+	if g.cluster != nil {
+		return []ReplicaLag{{Name: "slave", Error: ErrShardKeyRequired.Error()}}
+	}
+	names, dbs := g.conn.nodes()
+	lags := make([]ReplicaLag, len(dbs)-1)
+	eachNode(dbs[1:], func(i int, db *sql.DB) {
+		lags[i] = replicaLag(ctx, names[i+1], db)
+	})
+	return lags
+}
+
+// Ping connects once to the master and every slave of cfg and reports their health,
+// unlike New it does not retry, which suits probes and command line tools
+func Ping(ctx context.Context, cfg *Config) (Health, error) {
+	names, dbs, errs, err := openNodes(cfg)
+	if err != nil {
+		return Health{}, err
+	}
+	defer closeNodes(dbs)
+
+	nodes := make([]NodeHealth, len(dbs))
+	eachNode(dbs, func(i int, db *sql.DB) {
+		if errs[i] != nil {
+			nodes[i] = NodeHealth{Name: names[i], Error: errs[i].Error()}
+			return
+		}
+		nodes[i] = pingNode(ctx, names[i], db)
+	})
+	return newHealth(nodes), nil
+}
+
+// ReplicaLagOf connects once to every slave of cfg and measures its replication lag
+func ReplicaLagOf(ctx context.Context, cfg *Config) ([]ReplicaLag, error) {
+	names, dbs, errs, err := openNodes(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer closeNodes(dbs)
+
+	lags := make([]ReplicaLag, len(dbs)-1)
+	eachNode(dbs[1:], func(i int, db *sql.DB) {
+		if errs[i+1] != nil {
+			lags[i] = ReplicaLag{Name: names[i+1], Error: errs[i+1].Error()}
+			return
+		}
+		lags[i] = replicaLag(ctx, names[i+1], db)
+	})
+	return lags, nil
+}
+
+// OpenMasterDB opens a database/sql pool on the master of cfg, for tools such as migrate that need no gorm.
+// It does not connect yet.
+func OpenMasterDB(cfg *Config) (*sql.DB, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	config, err := cfg.clone()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.setConnectionInfo(); err != nil {
+		return nil, err
+	}
+	db, err := config.openSQLDB(config.Master, newPoolSettings(config))
+	if err != nil {
+		return nil, err
+	}
+	config.setPool(db)
+	return db, nil
+}
+
+func (conn *connection) nodes() ([]string, []*sql.DB) {
+	names := []string{"master"}
+	dbs := []*sql.DB{conn.master}
+	for i, r := range conn.replicas.list() {
+		names = append(names, fmt.Sprintf("slave[%d]", i))
+		dbs = append(dbs, r.db)
+	}
+	return names, dbs
+}
+
+// openNodes opens the master and the slaves of cfg, a node that fails to open has a nil db and its error set
+func openNodes(cfg *Config) (names []string, dbs []*sql.DB, errs []error, err error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, nil, err
+	}
+	config, err := cfg.clone()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := config.setConnectionInfo(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	ps := newPoolSettings(config)
+	ccs := append([]ConnConfig{config.Master}, config.Slave...)
+	names = make([]string, len(ccs))
+	dbs = make([]*sql.DB, len(ccs))
+	errs = make([]error, len(ccs))
+	for i, cc := range ccs {
+		names[i] = "master"
+		if i > 0 {
+			names[i] = fmt.Sprintf("slave[%d]", i-1)
+		}
+		dbs[i], errs[i] = config.openSQLDB(cc, ps)
+	}
+	return names, dbs, errs, nil
+}
+
+func closeNodes(dbs []*sql.DB) {
+	for _, db := range dbs {
+		if db != nil {
+			db.Close()
+		}
+	}
+}
+
+func eachNode(dbs []*sql.DB, fn func(i int, db *sql.DB)) {
+	var wg sync.WaitGroup
+	for i := range dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fn(i, dbs[i])
+		}(i)
+	}
+	wg.Wait()
+}
+
+func newHealth(nodes []NodeHealth) Health {
+	h := Health{
+		Healthy: nodes[0].Healthy,
+		Nodes:   nodes,
+	}
+	for _, n := range nodes[1:] {
+		if !n.Healthy {
+			h.Degraded = true
+		}
+	}
+	return h
+}
+
+func pingNode(ctx context.Context, name string, db *sql.DB) NodeHealth {
+	start := time.Now()
+	err := db.PingContext(ctx)
+	n := NodeHealth{
+		Name:    name,
+		Healthy: err == nil,
+		Latency: time.Since(start),
+		Stats:   db.Stats(),
+	}
+	if err != nil {
+		n.Error = err.Error()
+	}
+	return n
+}
+
+func replicaLag(ctx context.Context, name string, db *sql.DB) ReplicaLag {
+	lag := ReplicaLag{
+		Name: name,
+	}
+	var seconds float64
+	if err := db.QueryRowContext(ctx, replicaLagQuery).Scan(&lag.InRecovery, &seconds); err != nil {
+		lag.Error = err.Error()
+		return lag
+	}
+	lag.Lag = time.Duration(seconds * float64(time.Second))
+	return lag
+}
@@ -0,0 +1,175 @@
+// Package pgcatalog reads table definitions from the postgres catalog
+package pgcatalog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Column is one column of a table
+type Column struct {
+	Name string
+	// DataType is the information_schema data type, e.g. character varying
+	DataType string
+	// UDTName is the postgres type name, e.g. varchar or int8
+	UDTName  string
+	Nullable bool
+	// MaxLength is the length limit of character types, 0 when there is none
+	MaxLength int
+	Default   sql.NullString
+}
+
+// Index is one index of a table, unique and primary key constraints included
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+	Primary bool
+}
+
+// Table is a table with its columns in definition order
+type Table struct {
+	Schema  string
+	Name    string
+	Columns []Column
+	Indexes []Index
+}
+
+// Column returns the column called name
+func (t *Table) Column(name string) (Column, bool) {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Column{}, false
+}
+
+// Queryer is the part of *sql.DB, *sql.Conn and *sql.Tx used to read the catalog
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+const columnsQuery = `SELECT table_schema, table_name, column_name, data_type, udt_name, is_nullable = 'YES',
+	COALESCE(character_maximum_length, 0), column_default
+FROM information_schema.columns
+WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema())
+ORDER BY table_name, ordinal_position`
+
+const indexesQuery = `SELECT t.relname, i.relname, ix.indisunique, ix.indisprimary,
+	string_agg(a.attname, ',' ORDER BY k.n)
+FROM pg_index ix
+JOIN pg_class t ON t.oid = ix.indrelid
+JOIN pg_class i ON i.oid = ix.indexrelid
+JOIN pg_namespace ns ON ns.oid = t.relnamespace
+JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, n) ON true
+JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+WHERE ns.nspname = COALESCE(NULLIF($1, ''), current_schema())
+GROUP BY t.relname, i.relname, ix.indisunique, ix.indisprimary
+ORDER BY t.relname, i.relname`
+
+// Tables reads every table of schema keyed by name, an empty schema means the current one.
+// Expression indexes only list their plain columns.
+func Tables(ctx context.Context, q Queryer, schema string) (map[string]*Table, error) {
+	tables := make(map[string]*Table)
+
+	rows, err := q.QueryContext(ctx, columnsQuery, schema)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("read columns: %v", err))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			tableSchema, tableName string
+			c                      Column
+		)
+		if err := rows.Scan(&tableSchema, &tableName, &c.Name, &c.DataType, &c.UDTName, &c.Nullable, &c.MaxLength, &c.Default); err != nil {
+			return nil, errors.WithStack(fmt.Errorf("read columns: %v", err))
+		}
+		t, ok := tables[tableName]
+		if !ok {
+			t = &Table{
+				Schema: tableSchema,
+				Name:   tableName,
+			}
+			tables[tableName] = t
+		}
+		t.Columns = append(t.Columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("read columns: %v", err))
+	}
+
+	idxRows, err := q.QueryContext(ctx, indexesQuery, schema)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("read indexes: %v", err))
+	}
+	defer idxRows.Close()
+	for idxRows.Next() {
+		var (
+			tableName, columns string
+			idx                Index
+		)
+		if err := idxRows.Scan(&tableName, &idx.Name, &idx.Unique, &idx.Primary, &columns); err != nil {
+			return nil, errors.WithStack(fmt.Errorf("read indexes: %v", err))
+		}
+		idx.Columns = strings.Split(columns, ",")
+		if t, ok := tables[tableName]; ok {
+			t.Indexes = append(t.Indexes, idx)
+		}
+	}
+	if err := idxRows.Err(); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("read indexes: %v", err))
+	}
+
+	return tables, nil
+}
+
+var typeAliases = map[string]string{
+	"bigserial":                   "int8",
+	"bigint":                      "int8",
+	"serial8":                     "int8",
+	"serial":                      "int4",
+	"serial4":                     "int4",
+	"integer":                     "int4",
+	"int":                         "int4",
+	"smallserial":                 "int2",
+	"serial2":                     "int2",
+	"smallint":                    "int2",
+	"boolean":                     "bool",
+	"character varying":           "varchar",
+	"character":                   "bpchar",
+	"char":                        "bpchar",
+	"decimal":                     "numeric",
+	"real":                        "float4",
+	"float":                       "float8",
+	"double precision":            "float8",
+	"timestamp with time zone":    "timestamptz",
+	"timestamp without time zone": "timestamp",
+	"time with time zone":         "timetz",
+	"time without time zone":      "time",
+}
+
+// NormalizeType turns a type as written in DDL, e.g. varchar(128) or bigserial, into the catalog udt name
+// and its length, 0 when it has none. Precision and scale are dropped.
+func NormalizeType(t string) (string, int) {
+	t = strings.ToLower(strings.TrimSpace(t))
+	length := 0
+	if i, j := strings.Index(t, "("), strings.Index(t, ")"); i >= 0 && j > i {
+		fmt.Sscanf(t[i+1:j], "%d", &length)
+		t = strings.Join(strings.Fields(t[:i]+" "+t[j+1:]), " ")
+	}
+	if alias, ok := typeAliases[t]; ok {
+		t = alias
+	}
+	switch t {
+	case "varchar", "bpchar":
+	default:
+		length = 0
+	}
+	return t, length
+}
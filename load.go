@@ -0,0 +1,259 @@
+package wgorm
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// Source is where a config value came from
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+)
+
+// Sources maps the yaml path of every value that was set, e.g. slave[0].port, to its source
+type Sources map[string]Source
+
+// Paths returns the recorded paths sorted
+func (s Sources) Paths() []string {
+	paths := make([]string, 0, len(s))
+	for p := range s {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// LoadOptions tells LoadConfig where to look for the config
+type LoadOptions struct {
+	// File is the yaml file to read, empty skips the file
+	File string
+	// Key is the key the database config is nested under in the file, e.g. database. Empty means the file root.
+	Key string
+	// EnvPrefix enables the environment overlay, with DB the master host is read from DB_MASTER_HOST
+	// and the second slave port from DB_SLAVE_1_PORT. Maps take k1=v1,k2=v2.
+	EnvPrefix string
+}
+
+// LoadConfig reads the config from the yaml file, overlays the environment variables and applies the defaults.
+// It returns where each value came from.
+func LoadConfig(opts LoadOptions) (*Config, Sources, error) {
+	var cfg Config
+	sources := make(Sources)
+
+	if opts.File != "" {
+		v := viper.New()
+		v.SetConfigFile(opts.File)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, nil, errors.WithStack(fmt.Errorf("read config file: %v", err))
+		}
+
+		raw := v.AllSettings()
+		var section interface{} = raw
+		if opts.Key != "" {
+			section = v.Get(opts.Key)
+			if section == nil {
+				return nil, nil, errors.WithStack(fmt.Errorf("key %q not found in %s", opts.Key, opts.File))
+			}
+		}
+
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			TagName:          "yaml",
+			WeaklyTypedInput: true,
+			Result:           &cfg,
+		})
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		if err := decoder.Decode(section); err != nil {
+			return nil, nil, errors.WithStack(fmt.Errorf("decode config: %v", err))
+		}
+		recordSources(section, "", SourceFile, sources)
+	}
+
+	if opts.EnvPrefix != "" {
+		if err := overlayEnv(reflect.ValueOf(&cfg).Elem(), strings.ToUpper(opts.EnvPrefix), "", sources); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, path := range cfg.setDefaults() {
+		sources[path] = SourceDefault
+	}
+
+	return &cfg, sources, nil
+}
+
+// defaults of the pool settings left at zero
+const (
+	defaultMaxIdleConns       = 50
+	defaultMaxOpenConns       = 100
+	defaultConnMaxLifeTimeSec = 3600
+)
+
+// setDefaults fills the pool settings left at zero and returns their yaml paths
+func (cfg *Config) setDefaults() []string {
+	var paths []string
+	if cfg.MaxIdleConns == 0 {
+		cfg.MaxIdleConns = defaultMaxIdleConns
+		paths = append(paths, "max_idle_conns")
+	}
+	if cfg.MaxOpenConns == 0 {
+		cfg.MaxOpenConns = defaultMaxOpenConns
+		paths = append(paths, "max_open_conns")
+	}
+	if cfg.ConnMaxLifeTimeSec == 0 {
+		cfg.ConnMaxLifeTimeSec = defaultConnMaxLifeTimeSec
+		paths = append(paths, "conn_max_life_time_sec")
+	}
+	return paths
+}
+
+// recordSources marks every leaf of the raw config tree
+func recordSources(raw interface{}, path string, source Source, sources Sources) {
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			recordSources(child, joinPath(path, k), source, sources)
+		}
+	case map[interface{}]interface{}:
+		for k, child := range v {
+			recordSources(child, joinPath(path, fmt.Sprint(k)), source, sources)
+		}
+	case []interface{}:
+		for i, child := range v {
+			recordSources(child, fmt.Sprintf("%s[%d]", path, i), source, sources)
+		}
+	default:
+		if path != "" {
+			sources[path] = source
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// overlayEnv walks the yaml tags of v and sets every field that has a matching environment variable
+func overlayEnv(v reflect.Value, envPrefix, path string, sources Sources) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		fieldPath := joinPath(path, tag)
+		env := envPrefix + "_" + strings.ToUpper(tag)
+		fv := v.Field(i)
+
+		switch fv.Kind() {
+		case reflect.Struct:
+			if err := overlayEnv(fv, env, fieldPath, sources); err != nil {
+				return err
+			}
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() != reflect.Struct {
+				continue
+			}
+			// grow the slice when the environment describes more elements than the file
+			if n := envSliceLen(env); n > fv.Len() {
+				grown := reflect.MakeSlice(fv.Type(), n, n)
+				reflect.Copy(grown, fv)
+				fv.Set(grown)
+			}
+			for j := 0; j < fv.Len(); j++ {
+				if err := overlayEnv(fv.Index(j), fmt.Sprintf("%s_%d", env, j), fmt.Sprintf("%s[%d]", fieldPath, j), sources); err != nil {
+					return err
+				}
+			}
+		default:
+			raw, ok := os.LookupEnv(env)
+			if !ok {
+				continue
+			}
+			if err := setFromEnv(fv, raw); err != nil {
+				return errors.WithStack(fmt.Errorf("env %s: %v", env, err))
+			}
+			sources[fieldPath] = SourceEnv
+		}
+	}
+	return nil
+}
+
+// envSliceLen returns the number of elements the environment variables named prefix_<index>_* describe,
+// one past the highest index
+func envSliceLen(prefix string) int {
+	n := 0
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, prefix+"_") {
+			continue
+		}
+		rest := kv[len(prefix)+1:]
+		i := strings.IndexByte(rest, '_')
+		if i <= 0 {
+			continue
+		}
+		if index, err := strconv.Atoi(rest[:i]); err == nil && index >= n {
+			n = index + 1
+		}
+	}
+	return n
+}
+
+func setFromEnv(fv reflect.Value, raw string) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Map:
+		if fv.Type().Key().Kind() != reflect.String || fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map type %s", fv.Type())
+		}
+		m := reflect.MakeMap(fv.Type())
+		for _, pair := range strings.Split(raw, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("expect k=v pairs, got %q", pair)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(kv[0])).Convert(fv.Type().Key()), reflect.ValueOf(kv[1]).Convert(fv.Type().Elem()))
+		}
+		fv.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
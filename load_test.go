@@ -0,0 +1,188 @@
+package wgorm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// setEnv sets the environment variables for the duration of the test
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for k, v := range env {
+		old, had := os.LookupEnv(k)
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+		k := k
+		t.Cleanup(func() {
+			if had {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "wgorm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const loadTestFile = `
+database:
+  driver: postgres
+  max_idle_conns: 10
+  master:
+    host: master
+    port: 5432
+    username: app
+    dbname: db
+  slave:
+    - host: slave0
+      port: 5432
+  log:
+    log_level: 2
+`
+
+func TestLoadConfig(t *testing.T) {
+	file := writeConfigFile(t, loadTestFile)
+
+	tests := []struct {
+		name    string
+		opts    LoadOptions
+		env     map[string]string
+		check   func(t *testing.T, cfg *Config)
+		sources map[string]Source
+		wantErr bool
+	}{
+		{
+			name: "file with defaults",
+			opts: LoadOptions{File: file, Key: "database"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Master.Host != "master" || cfg.MaxIdleConns != 10 || cfg.MaxOpenConns != defaultMaxOpenConns || len(cfg.Slave) != 1 {
+					t.Errorf("unexpected config %+v", cfg)
+				}
+			},
+			sources: map[string]Source{
+				"master.host":            SourceFile,
+				"max_idle_conns":         SourceFile,
+				"max_open_conns":         SourceDefault,
+				"conn_max_life_time_sec": SourceDefault,
+				"log.log_level":          SourceFile,
+			},
+		},
+		{
+			name: "env overrides the file",
+			opts: LoadOptions{File: file, Key: "database", EnvPrefix: "wgormtest"},
+			env: map[string]string{
+				"WGORMTEST_MASTER_HOST":           "env-master",
+				"WGORMTEST_MAX_IDLE_CONNS":        "20",
+				"WGORMTEST_SLAVE_0_PORT":          "6543",
+				"WGORMTEST_MASTER_SSL_ENABLE":     "true",
+				"WGORMTEST_LOG_LOG_LEVEL":         "4",
+				"WGORMTEST_MASTER_RUNTIME_PARAMS": "work_mem=64MB, search_path=app",
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Master.Host != "env-master" || cfg.MaxIdleConns != 20 || cfg.Slave[0].Port != 6543 || cfg.Slave[0].Host != "slave0" {
+					t.Errorf("unexpected config %+v", cfg)
+				}
+				if !cfg.Master.SSLEnable || cfg.Log.LogLevel != 4 {
+					t.Errorf("unexpected ssl_enable %v or log_level %v", cfg.Master.SSLEnable, cfg.Log.LogLevel)
+				}
+				want := map[string]string{"work_mem": "64MB", "search_path": "app"}
+				if !reflect.DeepEqual(cfg.Master.RuntimeParams, want) {
+					t.Errorf("runtime_params = %v, want %v", cfg.Master.RuntimeParams, want)
+				}
+			},
+			sources: map[string]Source{
+				"master.host":           SourceEnv,
+				"master.username":       SourceFile,
+				"max_idle_conns":        SourceEnv,
+				"slave[0].port":         SourceEnv,
+				"slave[0].host":         SourceFile,
+				"master.runtime_params": SourceEnv,
+			},
+		},
+		{
+			name: "env adds slaves",
+			opts: LoadOptions{File: file, Key: "database", EnvPrefix: "WGORMTEST"},
+			env:  map[string]string{"WGORMTEST_SLAVE_2_HOST": "slave2"},
+			check: func(t *testing.T, cfg *Config) {
+				if len(cfg.Slave) != 3 || cfg.Slave[2].Host != "slave2" || cfg.Slave[0].Host != "slave0" {
+					t.Errorf("unexpected slaves %+v", cfg.Slave)
+				}
+			},
+			sources: map[string]Source{"slave[2].host": SourceEnv},
+		},
+		{
+			name: "env only",
+			opts: LoadOptions{EnvPrefix: "WGORMTEST"},
+			env:  map[string]string{"WGORMTEST_DRIVER": "postgres", "WGORMTEST_MASTER_PORT": "5433"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Driver != Postgres || cfg.Master.Port != 5433 {
+					t.Errorf("unexpected config %+v", cfg)
+				}
+			},
+			sources: map[string]Source{"driver": SourceEnv, "master.port": SourceEnv},
+		},
+		{
+			name:    "invalid int",
+			opts:    LoadOptions{EnvPrefix: "WGORMTEST"},
+			env:     map[string]string{"WGORMTEST_MAX_OPEN_CONNS": "many"},
+			wantErr: true,
+		},
+		{
+			name:    "port out of range",
+			opts:    LoadOptions{EnvPrefix: "WGORMTEST"},
+			env:     map[string]string{"WGORMTEST_MASTER_PORT": "5000000000"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid map",
+			opts:    LoadOptions{EnvPrefix: "WGORMTEST"},
+			env:     map[string]string{"WGORMTEST_MASTER_RUNTIME_PARAMS": "work_mem"},
+			wantErr: true,
+		},
+		{
+			name:    "missing key",
+			opts:    LoadOptions{File: file, Key: "db"},
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			opts:    LoadOptions{File: filepath.Join(filepath.Dir(file), "missing.yaml")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+			cfg, sources, err := LoadConfig(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			tt.check(t, cfg)
+			for path, want := range tt.sources {
+				if got := sources[path]; got != want {
+					t.Errorf("source of %s = %q, want %q", path, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package logger
+
+import (
+	"context"
+	stdlog "log"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Adapter is the backend the sql logger writes formatted messages to,
+// implement it to plug in logrus, zap or any other logging library.
+type Adapter interface {
+	Log(ctx context.Context, level LogLevel, msg string)
+}
+
+// LevelEnabler is implemented by adapters that can tell whether a message of level would be dropped,
+// the sql logger then skips formatting it
+type LevelEnabler interface {
+	Enabled(ctx context.Context, level LogLevel) bool
+}
+
+// AdapterFunc is a function implementing Adapter
+type AdapterFunc func(ctx context.Context, level LogLevel, msg string)
+
+// Log calls f(ctx, level, msg)
+func (f AdapterFunc) Log(ctx context.Context, level LogLevel, msg string) {
+	f(ctx, level, msg)
+}
+
+type zerologAdapter struct {
+	fallback *zerolog.Logger
+}
+
+// NewZerologAdapter writes to the zerolog logger attached to the context,
+// fallback is used when the context has none. A nil fallback drops the message.
+func NewZerologAdapter(fallback *zerolog.Logger) Adapter {
+	return &zerologAdapter{
+		fallback: fallback,
+	}
+}
+
+// logger returns the logger of ctx, the fallback when ctx has none, nil when the message is dropped.
+// A disabled logger attached to ctx turns logging off rather than falling back.
+func (a *zerologAdapter) logger(ctx context.Context) *zerolog.Logger {
+	l := zerolog.Ctx(ctx)
+	if l == zerolog.Ctx(context.Background()) {
+		// no logger attached
+		l = a.fallback
+	}
+	if l == nil || l.GetLevel() == zerolog.Disabled {
+		return nil
+	}
+	return l
+}
+
+// Enabled reports whether the logger of ctx writes messages of level
+func (a *zerologAdapter) Enabled(ctx context.Context, level LogLevel) bool {
+	l := a.logger(ctx)
+	if l == nil {
+		return false
+	}
+	var zl zerolog.Level
+	switch level {
+	case Error:
+		zl = zerolog.ErrorLevel
+	case Warn:
+		zl = zerolog.WarnLevel
+	case Info:
+		zl = zerolog.InfoLevel
+	default:
+		return false
+	}
+	return zl >= l.GetLevel() && zl >= zerolog.GlobalLevel()
+}
+
+func (a *zerologAdapter) Log(ctx context.Context, level LogLevel, msg string) {
+	l := a.logger(ctx)
+	if l == nil {
+		return
+	}
+
+	switch level {
+	case Error:
+		l.Error().Msg(msg)
+	case Warn:
+		l.Warn().Msg(msg)
+	case Info:
+		l.Info().Msg(msg)
+	}
+}
+
+type stdAdapter struct {
+	l *stdlog.Logger
+}
+
+// NewStdAdapter writes to a standard library logger, the level is added as a prefix
+func NewStdAdapter(l *stdlog.Logger) Adapter {
+	return &stdAdapter{
+		l: l,
+	}
+}
+
+func (a *stdAdapter) Log(ctx context.Context, level LogLevel, msg string) {
+	a.l.Printf("[%s] %s", level, msg)
+}
+
+// String returns the level name
+func (level LogLevel) String() string {
+	switch level {
+	case Silent:
+		return "silent"
+	case Error:
+		return "error"
+	case Warn:
+		return "warn"
+	case Info:
+		return "info"
+	}
+	return "unknown"
+}
+
+func (cfg Config) adapter() Adapter {
+	if cfg.Adapter != nil {
+		return cfg.Adapter
+	}
+	if cfg.Fallback != nil {
+		return NewZerologAdapter(cfg.Fallback)
+	}
+	if cfg.UseGlobalFallback {
+		return NewZerologAdapter(&log.Logger)
+	}
+	return NewZerologAdapter(nil)
+}
@@ -0,0 +1,111 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestTraceFallback(t *testing.T) {
+	var fallback, attached bytes.Buffer
+	fl := zerolog.New(&fallback)
+	l := New(Config{LogLevel: Info, Fallback: &fl})
+	fc := func() (string, int64) { return "SELECT 1", 1 }
+
+	l.Trace(context.Background(), time.Now(), fc, nil)
+	if !strings.Contains(fallback.String(), `"level":"info"`) || !strings.Contains(fallback.String(), "SELECT 1") {
+		t.Errorf("fallback got %q, want the info trace", fallback.String())
+	}
+
+	fallback.Reset()
+	al := zerolog.New(&attached)
+	ctx := al.WithContext(context.Background())
+	l.Trace(ctx, time.Now(), fc, errors.New("boom"))
+	if fallback.Len() != 0 || !strings.Contains(attached.String(), `"level":"error"`) {
+		t.Errorf("fallback got %q and the context logger %q, want only the context logger", fallback.String(), attached.String())
+	}
+
+	// zerolog only attaches a disabled logger in place of another one
+	dl := zerolog.New(&attached).Level(zerolog.Disabled)
+	disabled := dl.WithContext(ctx)
+	attached.Reset()
+	l.Trace(disabled, time.Now(), fc, errors.New("boom"))
+	if fallback.Len() != 0 || attached.Len() != 0 {
+		t.Errorf("a disabled context logger wrote %q to the fallback and %q to itself", fallback.String(), attached.String())
+	}
+
+	fallback.Reset()
+	New(Config{LogLevel: Info}).Trace(context.Background(), time.Now(), fc, nil)
+	if fallback.Len() != 0 {
+		t.Errorf("logger without fallback wrote %q", fallback.String())
+	}
+}
+
+func TestTraceAdapter(t *testing.T) {
+	l, entries := captureLogger(Config{LogLevel: Info})
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT * FROM users", 7 }, nil)
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "DELETE FROM users", -1 }, errors.New("boom"))
+	if len(*entries) != 2 {
+		t.Fatalf("adapter got %d messages, want 2", len(*entries))
+	}
+	info, errEntry := (*entries)[0], (*entries)[1]
+	if info.level != Info || !strings.Contains(info.msg, "[rows:7] SELECT * FROM users") {
+		t.Errorf("info message = %v %q", info.level, info.msg)
+	}
+	if errEntry.level != Error || !strings.Contains(errEntry.msg, "boom") || !strings.Contains(errEntry.msg, "[rows:-] DELETE FROM users") {
+		t.Errorf("error message = %v %q", errEntry.level, errEntry.msg)
+	}
+}
+
+// levelAdapter only enables the levels up to max
+type levelAdapter struct {
+	max    LogLevel
+	logged []LogLevel
+}
+
+func (a *levelAdapter) Log(ctx context.Context, level LogLevel, msg string) {
+	a.logged = append(a.logged, level)
+}
+
+func (a *levelAdapter) Enabled(ctx context.Context, level LogLevel) bool {
+	return level <= a.max
+}
+
+func TestTraceLevelEnabler(t *testing.T) {
+	a := &levelAdapter{max: Warn}
+	l := New(Config{LogLevel: Info, Adapter: a})
+	formatted := 0
+	fc := func() (string, int64) {
+		formatted++
+		return "SELECT 1", 1
+	}
+
+	l.Trace(context.Background(), time.Now(), fc, nil)
+	l.Info(context.Background(), "dropped")
+	l.Trace(context.Background(), time.Now(), fc, errors.New("boom"))
+	l.Warn(context.Background(), "kept")
+	if formatted != 1 {
+		t.Errorf("formatted %d statements, want only the error one", formatted)
+	}
+	if len(a.logged) != 2 || a.logged[0] != Error || a.logged[1] != Warn {
+		t.Errorf("adapter got levels %v, want [error warn]", a.logged)
+	}
+}
+
+func TestZerologAdapterEnabled(t *testing.T) {
+	var buf bytes.Buffer
+	fl := zerolog.New(&buf).Level(zerolog.WarnLevel)
+	a := NewZerologAdapter(&fl).(LevelEnabler)
+	ctx := context.Background()
+	if a.Enabled(ctx, Info) || !a.Enabled(ctx, Warn) || !a.Enabled(ctx, Error) {
+		t.Error("fallback at warn level should enable warn and error only")
+	}
+	if NewZerologAdapter(nil).(LevelEnabler).Enabled(ctx, Error) {
+		t.Error("adapter without a logger enabled error")
+	}
+}
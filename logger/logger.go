@@ -4,9 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 	gormLogger "gorm.io/gorm/logger"
 	"gorm.io/gorm/utils"
 )
@@ -43,16 +44,53 @@ type Config struct {
 	IgnoreRecordNotFoundError bool     `yaml:"ignore_record_not_found_error" mapstructure:"ignore_record_not_found_error"`
 	Colorful                  bool     `yaml:"colorful" mapstructure:"colorful"`
 
+	// SampleRate keeps 1 in N info level statements, errors and slow queries are always kept
+	SampleRate int `yaml:"sample_rate" mapstructure:"sample_rate"`
+	// RateLimitPerSec limits info level statements per second for each sql fingerprint
+	RateLimitPerSec int `yaml:"rate_limit_per_sec" mapstructure:"rate_limit_per_sec"`
+	// RateLimitBurst is the number of statements of one fingerprint allowed at once, defaults to RateLimitPerSec
+	RateLimitBurst int `yaml:"rate_limit_burst" mapstructure:"rate_limit_burst"`
+
+	// UseGlobalFallback writes to zerolog's global logger when the context has no logger attached
+	UseGlobalFallback bool `yaml:"use_global_fallback" mapstructure:"use_global_fallback"`
+	// Fallback is used when the context has no logger attached, takes precedence over UseGlobalFallback
+	Fallback *zerolog.Logger `yaml:"-" mapstructure:"-"`
+	// Adapter replaces zerolog as the log backend
+	Adapter Adapter `yaml:"-" mapstructure:"-"`
+
 	slowThreshold time.Duration
 }
 
+// Reloadable is implemented by the logger returned by New, Reload replaces its config at runtime
+type Reloadable interface {
+	Reload(cfg Config)
+}
+
 type logger struct {
+	// current is shared with the loggers returned by LogMode so they see Reload
+	current *atomic.Value
+	// level overrides the log level of the config when set by LogMode
+	level LogLevel
+}
+
+// settings is the immutable state a logger works with, Reload swaps it as a whole
+type settings struct {
 	cfg                                 Config
+	sampler                             *sampler
+	adapter                             Adapter
 	infoStr, warnStr, errStr            string
 	traceStr, traceErrStr, traceWarnStr string
 }
 
 func New(cfg Config) gormLogger.Interface {
+	l := &logger{
+		current: &atomic.Value{},
+	}
+	l.Reload(cfg)
+	return l
+}
+
+func newSettings(cfg Config) *settings {
 	var (
 		infoStr      = "%s\n[info] "
 		warnStr      = "%s\n[warn] "
@@ -73,8 +111,10 @@ func New(cfg Config) gormLogger.Interface {
 
 	cfg.slowThreshold = time.Duration(cfg.SlowThresholdMs) * time.Millisecond
 
-	return &logger{
+	return &settings{
 		cfg:          cfg,
+		sampler:      newSampler(cfg),
+		adapter:      cfg.adapter(),
 		infoStr:      infoStr,
 		warnStr:      warnStr,
 		errStr:       errStr,
@@ -84,63 +124,106 @@ func New(cfg Config) gormLogger.Interface {
 	}
 }
 
-// LogMode log mode
+// Reload replaces the config, statements logged afterwards use the new one
+func (l *logger) Reload(cfg Config) {
+	l.current.Store(newSettings(cfg))
+}
+
+func (l *logger) settings() *settings {
+	return l.current.Load().(*settings)
+}
+
+// enabled reports whether the adapter keeps messages of level, they are not formatted otherwise
+func (s *settings) enabled(ctx context.Context, level LogLevel) bool {
+	if e, ok := s.adapter.(LevelEnabler); ok {
+		return e.Enabled(ctx, level)
+	}
+	return true
+}
+
+// logLevel returns the level set by LogMode, the one of the config otherwise
+func (l *logger) logLevel(s *settings) LogLevel {
+	if l.level != 0 {
+		return l.level
+	}
+	return s.cfg.LogLevel
+}
+
+// LogMode returns a logger with level, it keeps following Reload for everything else
 func (l *logger) LogMode(level gormLogger.LogLevel) gormLogger.Interface {
-	newlogger := *l
-	newlogger.cfg.LogLevel = LogLevel(level)
-	return &newlogger
+	return &logger{
+		current: l.current,
+		level:   LogLevel(level),
+	}
 }
 
 // Info print info
 func (l logger) Info(ctx context.Context, msg string, data ...interface{}) {
-	if l.cfg.LogLevel >= Info {
-		log.Ctx(ctx).Info().Msgf(l.infoStr+msg, append([]interface{}{utils.FileWithLineNum()}, data...)...)
+	s := l.settings()
+	if l.logLevel(s) >= Info && s.enabled(ctx, Info) {
+		s.adapter.Log(ctx, Info, fmt.Sprintf(s.infoStr+msg, append([]interface{}{utils.FileWithLineNum()}, data...)...))
 	}
 }
 
 // Warn print warn messages
 func (l logger) Warn(ctx context.Context, msg string, data ...interface{}) {
-	if l.cfg.LogLevel >= Warn {
-		log.Ctx(ctx).Warn().Msgf(l.warnStr+msg, append([]interface{}{utils.FileWithLineNum()}, data...)...)
+	s := l.settings()
+	if l.logLevel(s) >= Warn && s.enabled(ctx, Warn) {
+		s.adapter.Log(ctx, Warn, fmt.Sprintf(s.warnStr+msg, append([]interface{}{utils.FileWithLineNum()}, data...)...))
 	}
 }
 
 // Error print error messages
 func (l logger) Error(ctx context.Context, msg string, data ...interface{}) {
-	if l.cfg.LogLevel >= Error {
-		log.Ctx(ctx).Error().Msgf(l.errStr+msg, append([]interface{}{utils.FileWithLineNum()}, data...)...)
+	s := l.settings()
+	if l.logLevel(s) >= Error && s.enabled(ctx, Error) {
+		s.adapter.Log(ctx, Error, fmt.Sprintf(s.errStr+msg, append([]interface{}{utils.FileWithLineNum()}, data...)...))
 	}
 }
 
 // Trace print sql message
 func (l logger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
-	if l.cfg.LogLevel <= Silent {
+	s := l.settings()
+	level := l.logLevel(s)
+	if level <= Silent {
 		return
 	}
 
 	elapsed := time.Since(begin)
 	switch {
-	case err != nil && l.cfg.LogLevel >= Error && (!errors.Is(err, gormLogger.ErrRecordNotFound) || !l.cfg.IgnoreRecordNotFoundError):
+	case err != nil && level >= Error && (!errors.Is(err, gormLogger.ErrRecordNotFound) || !s.cfg.IgnoreRecordNotFoundError):
+		if !s.enabled(ctx, Error) {
+			return
+		}
 		sql, rows := fc()
 		if rows == -1 {
-			log.Ctx(ctx).Error().Msgf(l.traceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, "-", sql)
+			s.adapter.Log(ctx, Error, fmt.Sprintf(s.traceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, "-", sql))
 		} else {
-			log.Ctx(ctx).Error().Msgf(l.traceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, rows, sql)
+			s.adapter.Log(ctx, Error, fmt.Sprintf(s.traceErrStr, utils.FileWithLineNum(), err, float64(elapsed.Nanoseconds())/1e6, rows, sql))
+		}
+	case elapsed > s.cfg.slowThreshold && s.cfg.slowThreshold != 0 && level >= Warn:
+		if !s.enabled(ctx, Warn) {
+			return
 		}
-	case elapsed > l.cfg.slowThreshold && l.cfg.slowThreshold != 0 && l.cfg.LogLevel >= Warn:
 		sql, rows := fc()
-		slowLog := fmt.Sprintf("SLOW SQL >= %v", l.cfg.slowThreshold)
+		slowLog := fmt.Sprintf("SLOW SQL >= %v", s.cfg.slowThreshold)
 		if rows == -1 {
-			log.Ctx(ctx).Warn().Msgf(l.traceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, "-", sql)
+			s.adapter.Log(ctx, Warn, fmt.Sprintf(s.traceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, "-", sql))
 		} else {
-			log.Ctx(ctx).Warn().Msgf(l.traceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, rows, sql)
+			s.adapter.Log(ctx, Warn, fmt.Sprintf(s.traceWarnStr, utils.FileWithLineNum(), slowLog, float64(elapsed.Nanoseconds())/1e6, rows, sql))
+		}
+	case level == Info:
+		if !s.enabled(ctx, Info) || !s.sampler.sample() {
+			return
 		}
-	case l.cfg.LogLevel == Info:
 		sql, rows := fc()
+		if !s.sampler.allow(sql) {
+			return
+		}
 		if rows == -1 {
-			log.Ctx(ctx).Info().Msgf(l.traceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, "-", sql)
+			s.adapter.Log(ctx, Info, fmt.Sprintf(s.traceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, "-", sql))
 		} else {
-			log.Ctx(ctx).Info().Msgf(l.traceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, rows, sql)
+			s.adapter.Log(ctx, Info, fmt.Sprintf(s.traceStr, utils.FileWithLineNum(), float64(elapsed.Nanoseconds())/1e6, rows, sql))
 		}
 	}
 }
@@ -0,0 +1,39 @@
+package logger
+
+import (
+	"context"
+	"testing"
+
+	gormLogger "gorm.io/gorm/logger"
+)
+
+func TestLogModeFollowsReload(t *testing.T) {
+	l, entries := captureLogger(Config{LogLevel: Warn})
+	ctx := context.Background()
+
+	debug := l.LogMode(gormLogger.Info)
+	debug.Info(ctx, "first")
+	l.Info(ctx, "dropped")
+	if len(*entries) != 1 {
+		t.Fatalf("logged %d messages, want only the one of the Info level logger", len(*entries))
+	}
+
+	var reloaded []logEntry
+	l.Reload(Config{
+		LogLevel: Error,
+		Adapter: AdapterFunc(func(ctx context.Context, level LogLevel, msg string) {
+			reloaded = append(reloaded, logEntry{level: level, msg: msg})
+		}),
+	})
+	debug.Info(ctx, "second")
+	l.Warn(ctx, "dropped")
+	if len(*entries) != 1 || len(reloaded) != 1 || reloaded[0].level != Info {
+		t.Errorf("after Reload the LogMode logger wrote %v to the old adapter and %v to the new one", *entries, reloaded)
+	}
+
+	silent := debug.LogMode(gormLogger.Silent)
+	silent.Error(ctx, "dropped")
+	if len(reloaded) != 1 {
+		t.Errorf("silent logger wrote %v", reloaded[1:])
+	}
+}
@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxFingerprints bounds the number of rate limit buckets kept in memory
+const maxFingerprints = 10000
+
+var (
+	stringLiteralRe = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numberLiteralRe = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	inListRe        = regexp.MustCompile(`(?i)\bIN\s*\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	spaceRe         = regexp.MustCompile(`\s+`)
+)
+
+// sampler decides which info-level statement traces are logged.
+// Errors and slow queries never go through the sampler.
+type sampler struct {
+	rate    uint64
+	counter uint64
+
+	perSec float64
+	burst  float64
+
+	mu      sync.Mutex
+	buckets map[uint64]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newSampler(cfg Config) *sampler {
+	s := &sampler{
+		buckets: make(map[uint64]*bucket),
+	}
+	if cfg.SampleRate > 1 {
+		s.rate = uint64(cfg.SampleRate)
+	}
+	if cfg.RateLimitPerSec > 0 {
+		s.perSec = float64(cfg.RateLimitPerSec)
+		s.burst = float64(cfg.RateLimitBurst)
+		if s.burst < s.perSec {
+			s.burst = s.perSec
+		}
+	}
+	return s
+}
+
+// sample keeps 1 in SampleRate statements
+func (s *sampler) sample() bool {
+	if s == nil || s.rate == 0 {
+		return true
+	}
+	return atomic.AddUint64(&s.counter, 1)%s.rate == 1
+}
+
+// allow applies the per fingerprint token bucket
+func (s *sampler) allow(sql string) bool {
+	if s == nil || s.perSec == 0 {
+		return true
+	}
+
+	key := fingerprint(sql)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= maxFingerprints {
+			s.buckets = make(map[uint64]*bucket)
+		}
+		b = &bucket{tokens: s.burst, last: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * s.perSec
+	if b.tokens > s.burst {
+		b.tokens = s.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// fingerprint normalizes literals out of sql so that statements differing
+// only by their values share the same rate limit bucket
+func fingerprint(sql string) uint64 {
+	sql = stringLiteralRe.ReplaceAllString(sql, "?")
+	sql = numberLiteralRe.ReplaceAllString(sql, "?")
+	sql = inListRe.ReplaceAllString(sql, "IN (?)")
+	sql = spaceRe.ReplaceAllString(strings.TrimSpace(sql), " ")
+
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(sql)))
+	return h.Sum64()
+}
@@ -0,0 +1,147 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSamplerSample(t *testing.T) {
+	s := newSampler(Config{SampleRate: 3})
+	kept := 0
+	for i := 0; i < 9; i++ {
+		if s.sample() {
+			kept++
+		}
+	}
+	if kept != 3 {
+		t.Errorf("kept %d of 9, want 3", kept)
+	}
+
+	s = newSampler(Config{SampleRate: 1})
+	for i := 0; i < 3; i++ {
+		if !s.sample() {
+			t.Fatal("sample rate 1 dropped a statement")
+		}
+	}
+}
+
+func TestSamplerAllow(t *testing.T) {
+	const sql = "SELECT * FROM users WHERE id = 1"
+	s := newSampler(Config{RateLimitPerSec: 2, RateLimitBurst: 3})
+	allowed := func(n int) int {
+		got := 0
+		for i := 0; i < n; i++ {
+			if s.allow(sql) {
+				got++
+			}
+		}
+		return got
+	}
+	// ages the bucket of sql as if d passed
+	wait := func(d time.Duration) {
+		s.buckets[fingerprint(sql)].last = s.buckets[fingerprint(sql)].last.Add(-d)
+	}
+
+	if got := allowed(5); got != 3 {
+		t.Errorf("allowed %d at once, want the burst of 3", got)
+	}
+	wait(time.Second)
+	if got := allowed(5); got != 2 {
+		t.Errorf("allowed %d after a second, want 2", got)
+	}
+	wait(10 * time.Second)
+	if got := allowed(5); got != 3 {
+		t.Errorf("allowed %d after 10 seconds, want the burst of 3", got)
+	}
+	if !s.allow("SELECT * FROM orders") {
+		t.Error("another fingerprint shares the bucket of users")
+	}
+
+	s = newSampler(Config{RateLimitPerSec: 2})
+	if s.burst != 2 {
+		t.Errorf("burst = %v, want RateLimitPerSec", s.burst)
+	}
+}
+
+func TestSamplerBucketReset(t *testing.T) {
+	s := newSampler(Config{RateLimitPerSec: 1})
+	for i := 0; i < maxFingerprints; i++ {
+		s.allow(fmt.Sprintf("SELECT * FROM t%d", i))
+	}
+	if len(s.buckets) != maxFingerprints {
+		t.Fatalf("%d buckets, want %d", len(s.buckets), maxFingerprints)
+	}
+	s.allow("SELECT * FROM other")
+	if len(s.buckets) != 1 {
+		t.Errorf("%d buckets after the limit, want the buckets reset to 1", len(s.buckets))
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{a: "SELECT * FROM users WHERE id = 1", b: "SELECT * FROM users WHERE id = 42", same: true},
+		{a: "SELECT * FROM users WHERE name = 'bob'", b: "select * from users where name = 'it''s'", same: true},
+		{a: "SELECT * FROM users WHERE price > 1.5", b: "SELECT * FROM users WHERE price > 20", same: true},
+		{a: "SELECT * FROM users WHERE id IN (1)", b: "SELECT * FROM users WHERE id IN (1, 2, 3)", same: true},
+		{a: "SELECT * FROM users WHERE name IN ('a','b')", b: "SELECT  *\nFROM users WHERE name in ( 'c' )", same: true},
+		{a: "SELECT * FROM users", b: "SELECT * FROM orders"},
+		{a: "SELECT * FROM t1", b: "SELECT * FROM t2"},
+		{a: "SELECT * FROM users WHERE id = 1", b: "SELECT * FROM users WHERE age = 1"},
+	}
+	for _, tt := range tests {
+		if same := fingerprint(tt.a) == fingerprint(tt.b); same != tt.same {
+			t.Errorf("fingerprint(%q) == fingerprint(%q) is %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+}
+
+type logEntry struct {
+	level LogLevel
+	msg   string
+}
+
+// captureLogger returns a logger of cfg writing to the returned entries
+func captureLogger(cfg Config) (*logger, *[]logEntry) {
+	var entries []logEntry
+	cfg.Adapter = AdapterFunc(func(ctx context.Context, level LogLevel, msg string) {
+		entries = append(entries, logEntry{level: level, msg: msg})
+	})
+	return New(cfg).(*logger), &entries
+}
+
+func TestTraceSampling(t *testing.T) {
+	l, entries := captureLogger(Config{LogLevel: Info, SampleRate: 1000, RateLimitPerSec: 1, SlowThresholdMs: 100})
+	ctx := context.Background()
+	fc := func() (string, int64) { return "SELECT * FROM users WHERE id = 1", 1 }
+
+	for i := 0; i < 3; i++ {
+		l.Trace(ctx, time.Now(), fc, nil)
+	}
+	if len(*entries) != 1 {
+		t.Fatalf("logged %d info statements, want 1", len(*entries))
+	}
+
+	*entries = nil
+	for i := 0; i < 3; i++ {
+		l.Trace(ctx, time.Now(), fc, errors.New("boom"))
+		l.Trace(ctx, time.Now().Add(-time.Second), fc, nil)
+	}
+	var errs, slow int
+	for _, e := range *entries {
+		switch e.level {
+		case Error:
+			errs++
+		case Warn:
+			slow++
+		}
+	}
+	if errs != 3 || slow != 3 {
+		t.Errorf("logged %d errors and %d slow queries, want 3 and 3", errs, slow)
+	}
+}
@@ -0,0 +1,43 @@
+package logger
+
+import "fmt"
+
+// FieldError is a problem with one config field, Path is its yaml path such as slow_threshold_ms.
+// It is also the field error of the wgorm config.
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// Validate returns every invalid field of the config
+func (cfg Config) Validate() []FieldError {
+	var errs []FieldError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Path: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if cfg.LogLevel < 0 || cfg.LogLevel > Info {
+		add("log_level", "must be between %d (silent) and %d (info), got %d", Silent, Info, cfg.LogLevel)
+	}
+	if cfg.SlowThresholdMs < 0 {
+		add("slow_threshold_ms", "must not be negative")
+	}
+	if cfg.SampleRate < 0 {
+		add("sample_rate", "must not be negative")
+	}
+	if cfg.RateLimitPerSec < 0 {
+		add("rate_limit_per_sec", "must not be negative")
+	}
+	if cfg.RateLimitBurst < 0 {
+		add("rate_limit_burst", "must not be negative")
+	}
+	if cfg.RateLimitBurst > 0 && cfg.RateLimitPerSec == 0 {
+		add("rate_limit_burst", "requires rate_limit_per_sec")
+	}
+
+	return errs
+}
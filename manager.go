@@ -0,0 +1,198 @@
+package wgorm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/shoyo10/wgorm/logger"
+)
+
+// ManagerOption configures what the databases of a Manager share
+type ManagerOption func(m *Manager) *Manager
+
+// ManagerLogAdapter sets the log backend of every database whose log config has no adapter,
+// messages are prefixed with the database name
+func ManagerLogAdapter(adapter logger.Adapter) ManagerOption {
+	return func(m *Manager) *Manager {
+		m.adapter = adapter
+		return m
+	}
+}
+
+// ManagerLogFallback sets the fallback logger of every database whose log config has none
+func ManagerLogFallback(fallback *zerolog.Logger) ManagerOption {
+	return func(m *Manager) *Manager {
+		m.fallback = fallback
+		return m
+	}
+}
+
+// Manager holds the connections of several named databases, e.g. core, billing and audit
+type Manager struct {
+	adapter  logger.Adapter
+	fallback *zerolog.Logger
+
+	dbs map[string]*Gorm
+}
+
+// ManagerHealth is the health of every database of a Manager, it is healthy when all of them are
+type ManagerHealth struct {
+	Healthy   bool              `json:"healthy"`
+	Databases map[string]Health `json:"databases"`
+}
+
+// NewManager connects to all databases of cfgs concurrently.
+// When one of them fails the others are closed and the errors of all failed ones are returned.
+func NewManager(cfgs map[string]*Config, opts ...ManagerOption) (*Manager, error) {
+	m := &Manager{
+		dbs: make(map[string]*Gorm, len(cfgs)),
+	}
+	for _, opt := range opts {
+		m = opt(m)
+	}
+
+	names := make([]string, 0, len(cfgs))
+	for name := range cfgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var verrs []string
+	for _, name := range names {
+		if cfgs[name] == nil {
+			verrs = append(verrs, fmt.Sprintf("%s: config is nil", name))
+			continue
+		}
+		if err := cfgs[name].Validate(); err != nil {
+			verrs = append(verrs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(verrs) > 0 {
+		return nil, errors.WithStack(fmt.Errorf("invalid config: %s", strings.Join(verrs, "; ")))
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make(map[string]error)
+	)
+	for name, cfg := range cfgs {
+		wg.Add(1)
+		go func(name string, cfg *Config) {
+			defer wg.Done()
+			g, err := m.connect(name, cfg)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[name] = err
+				return
+			}
+			m.dbs[name] = g
+		}(name, cfg)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		m.Close()
+		msgs := make([]string, 0, len(errs))
+		for name, err := range errs {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", name, err))
+		}
+		sort.Strings(msgs)
+		return nil, errors.WithStack(fmt.Errorf("connect databases failed: %s", strings.Join(msgs, "; ")))
+	}
+
+	return m, nil
+}
+
+func (m *Manager) connect(name string, cfg *Config) (*Gorm, error) {
+	config, err := cfg.clone()
+	if err != nil {
+		return nil, err
+	}
+	if config.Log.Adapter == nil && m.adapter != nil {
+		adapter := m.adapter
+		prefix := "[" + name + "] "
+		config.Log.Adapter = logger.AdapterFunc(func(ctx context.Context, level logger.LogLevel, msg string) {
+			adapter.Log(ctx, level, prefix+msg)
+		})
+	}
+	if config.Log.Fallback == nil {
+		config.Log.Fallback = m.fallback
+	}
+
+	conn, err := newConnection(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Gorm{
+		conn: conn,
+	}, nil
+}
+
+// Get returns the database registered under name
+func (m *Manager) Get(name string) (*Gorm, error) {
+	g, ok := m.dbs[name]
+	if !ok {
+		return nil, errors.WithStack(fmt.Errorf("unknown database %q", name))
+	}
+	return g, nil
+}
+
+// Names returns the names of the databases sorted
+func (m *Manager) Names() []string {
+	names := make([]string, 0, len(m.dbs))
+	for name := range m.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Health checks all databases concurrently
+func (m *Manager) Health(ctx context.Context) ManagerHealth {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	h := ManagerHealth{
+		Healthy:   true,
+		Databases: make(map[string]Health, len(m.dbs)),
+	}
+	for name, g := range m.dbs {
+		wg.Add(1)
+		go func(name string, g *Gorm) {
+			defer wg.Done()
+			dh := g.Health(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			h.Databases[name] = dh
+			if !dh.Healthy {
+				h.Healthy = false
+			}
+		}(name, g)
+	}
+	wg.Wait()
+	return h
+}
+
+// Close closes every database, all of them are closed even when some fail
+func (m *Manager) Close() error {
+	var errs []string
+	for _, name := range m.Names() {
+		if err := m.dbs[name].Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.WithStack(fmt.Errorf("close databases failed: %s", strings.Join(errs, "; ")))
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shoyo10/wgorm/schemacheck"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// VersionFormat is the goose timestamp version format
+const VersionFormat = "20060102150405"
+
+var migrationName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// serialTypes are not valid in ALTER COLUMN TYPE
+var serialTypes = map[string]string{
+	"smallserial": "smallint",
+	"serial":      "integer",
+	"bigserial":   "bigint",
+}
+
+// zeroValues fill the existing rows when a NOT NULL column without a default is added
+var zeroValues = map[schema.DataType]string{
+	schema.Bool:   "false",
+	schema.Int:    "0",
+	schema.Uint:   "0",
+	schema.Float:  "0",
+	schema.String: "''",
+	schema.Time:   "CURRENT_TIMESTAMP",
+	schema.Bytes:  "''",
+}
+
+// Diff returns the statements bringing the tables of db in line with models, and the ones reverting them.
+// It diffs against the live database, not against the migration files: generate the next migration from
+// a database migrated to the latest one, changes made to it by hand would otherwise be missing.
+// Tables and columns without a model are left alone, nothing is ever dropped by the up statements.
+// It returns nil when the database already matches.
+func Diff(ctx context.Context, db *gorm.DB, models []interface{}, opts ...schemacheck.Option) (*Migration, error) {
+	report, err := schemacheck.Check(ctx, db, models, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return diff(db.WithContext(ctx), models, report)
+}
+
+// diff turns the mismatches of report into a migration
+func diff(db *gorm.DB, models []interface{}, report schemacheck.Report) (*Migration, error) {
+	if report.OK() {
+		return nil, nil
+	}
+
+	g := &generator{
+		db:      db,
+		schemas: make(map[string]*schema.Schema, len(models)),
+		added:   make(map[string]bool),
+	}
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: g.db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, errors.WithStack(fmt.Errorf("parse model %T: %v", model, err))
+		}
+		g.schemas[stmt.Schema.Table] = stmt.Schema
+	}
+
+	m := &Migration{}
+	for _, mismatch := range report.Mismatches {
+		up, down, err := g.statements(mismatch)
+		if err != nil {
+			return nil, err
+		}
+		m.Up = append(m.Up, up...)
+		m.Down = append(down, m.Down...)
+	}
+	return m, nil
+}
+
+// Generate diffs models against db and writes the result to a new goose file in dir named
+// <version>_<name>.sql, the version being the current UTC time. It returns the path of the file,
+// empty when the database already matches and no file was written.
+// The models are Go types, so the wgorm command has no generate subcommand: call Generate from
+// a small program or test of the service that imports them.
+func Generate(ctx context.Context, db *gorm.DB, models []interface{}, dir, name string, opts ...schemacheck.Option) (string, error) {
+	if !migrationName.MatchString(name) {
+		return "", errors.WithStack(fmt.Errorf("invalid migration name %q, use letters, digits and underscores", name))
+	}
+	m, err := Diff(ctx, db, models, opts...)
+	if err != nil || m == nil {
+		return "", err
+	}
+
+	m.Version, _ = parseVersion(time.Now().UTC().Format(VersionFormat) + "_")
+	m.Name = fmt.Sprintf("%d_%s.sql", m.Version, name)
+	path := filepath.Join(dir, m.Name)
+	if err := ioutil.WriteFile(path, m.Format(), 0644); err != nil {
+		return "", errors.WithStack(fmt.Errorf("write migration: %v", err))
+	}
+	return path, nil
+}
+
+// Format renders m as a goose annotated sql file
+func (m *Migration) Format() []byte {
+	var buf bytes.Buffer
+	if m.NoTx {
+		buf.WriteString("-- +goose NO TRANSACTION\n")
+	}
+	buf.WriteString("-- +goose Up\n")
+	for _, stmt := range m.Up {
+		buf.WriteString(ensureSemicolon(stmt) + "\n")
+	}
+	buf.WriteString("\n-- +goose Down\n")
+	for _, stmt := range m.Down {
+		buf.WriteString(ensureSemicolon(stmt) + "\n")
+	}
+	return buf.Bytes()
+}
+
+type generator struct {
+	db      *gorm.DB
+	schemas map[string]*schema.Schema
+	// added holds the table.column pairs added by the migration
+	added map[string]bool
+}
+
+func (g *generator) quote(name string) string {
+	stmt := &gorm.Statement{DB: g.db}
+	return stmt.Quote(name)
+}
+
+func (g *generator) quoteColumns(columns []string) string {
+	quoted := make([]string, 0, len(columns))
+	for _, c := range columns {
+		quoted = append(quoted, g.quote(c))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// statements turns one mismatch into its up and down statements
+func (g *generator) statements(m schemacheck.Mismatch) (up, down []string, err error) {
+	s, ok := g.schemas[m.Table]
+	if !ok {
+		return nil, nil, errors.WithStack(fmt.Errorf("no model for table %s", m.Table))
+	}
+	table := g.quote(s.Table)
+
+	switch m.Kind {
+	case schemacheck.MissingTable:
+		return g.createTable(s), []string{fmt.Sprintf("DROP TABLE IF EXISTS %s", table)}, nil
+	case schemacheck.MissingPrimaryIndex:
+		return []string{fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s)", table, g.quoteColumns(s.PrimaryFieldDBNames))},
+			[]string{fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", table, g.quote(s.Table+"_pkey"))}, nil
+	case schemacheck.MissingUniqueIndex:
+		columns := strings.Split(m.Column, ",")
+		// a unique column added by this migration already got its constraint inline
+		if f := s.LookUpField(columns[0]); len(columns) == 1 && f != nil && f.Unique && g.added[s.Table+"."+columns[0]] {
+			return nil, nil, nil
+		}
+		name := g.indexName(s, columns)
+		return []string{fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s)", g.quote(name), table, g.quoteColumns(columns))},
+			[]string{fmt.Sprintf("DROP INDEX IF EXISTS %s", g.quote(name))}, nil
+	}
+
+	field := s.LookUpField(m.Column)
+	if field == nil {
+		return nil, nil, errors.WithStack(fmt.Errorf("no field for column %s.%s", m.Table, m.Column))
+	}
+	column := g.quote(field.DBName)
+
+	switch m.Kind {
+	case schemacheck.MissingColumn:
+		g.added[s.Table+"."+field.DBName] = true
+		return g.addColumn(table, column, field),
+			[]string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", table, column)}, nil
+	case schemacheck.TypeMismatch:
+		expected := g.db.Dialector.DataTypeOf(field)
+		if t, ok := serialTypes[expected]; ok {
+			expected = t
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s", table, column, expected, column, expected)},
+			[]string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s", table, column, m.Actual, column, m.Actual)}, nil
+	case schemacheck.NullableMismatch:
+		set := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", table, column)
+		drop := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL", table, column)
+		if m.Expected == "NOT NULL" {
+			return []string{set}, []string{drop}, nil
+		}
+		return []string{drop}, []string{set}, nil
+	}
+	return nil, nil, errors.WithStack(fmt.Errorf("unsupported mismatch %s", m.Kind))
+}
+
+// addColumn adds a NOT NULL column without a default with the zero value of its type as a temporary
+// default, so the existing rows are filled in, and drops that default again. A type without a known
+// zero value is added nullable and set NOT NULL separately, the migration has to backfill it in between.
+func (g *generator) addColumn(table, column string, field *schema.Field) []string {
+	add := func(f *schema.Field, suffix string) string {
+		return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s%s", table, column, g.db.Migrator().FullDataTypeOf(f).SQL, suffix)
+	}
+	if !field.NotNull || field.HasDefaultValue {
+		return []string{add(field, "")}
+	}
+	if zero, ok := zeroValues[field.DataType]; ok {
+		return []string{
+			add(field, " DEFAULT "+zero),
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", table, column),
+		}
+	}
+	nullable := *field
+	nullable.NotNull = false
+	return []string{
+		add(&nullable, ""),
+		fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", table, column),
+	}
+}
+
+// createTable mirrors gorm's migrator: columns, primary key, then the tagged indexes
+func (g *generator) createTable(s *schema.Schema) []string {
+	var defs []string
+	for _, dbName := range s.DBNames {
+		field := s.FieldsByDBName[dbName]
+		if field.IgnoreMigration {
+			continue
+		}
+		defs = append(defs, fmt.Sprintf("\t%s %s", g.quote(dbName), g.db.Migrator().FullDataTypeOf(field).SQL))
+	}
+	if len(s.PrimaryFieldDBNames) > 0 {
+		defs = append(defs, fmt.Sprintf("\tPRIMARY KEY (%s)", g.quoteColumns(s.PrimaryFieldDBNames)))
+	}
+	stmts := []string{fmt.Sprintf("CREATE TABLE %s (\n%s\n)", g.quote(s.Table), strings.Join(defs, ",\n"))}
+
+	indexes := s.ParseIndexes()
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		idx := indexes[name]
+		var columns []string
+		for _, f := range idx.Fields {
+			if f.Field != nil {
+				columns = append(columns, f.DBName)
+			}
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		unique := ""
+		if idx.Class == "UNIQUE" {
+			unique = "UNIQUE "
+		}
+		using := ""
+		if idx.Type != "" {
+			using = " USING " + idx.Type
+		}
+		stmts = append(stmts, fmt.Sprintf("CREATE %sINDEX %s ON %s%s (%s)", unique, g.quote(idx.Name), g.quote(s.Table), using, g.quoteColumns(columns)))
+	}
+	return stmts
+}
+
+// indexName returns the name of the tagged index on columns, or gorm's default index name
+func (g *generator) indexName(s *schema.Schema, columns []string) string {
+	for name, idx := range s.ParseIndexes() {
+		if len(idx.Fields) != len(columns) {
+			continue
+		}
+		same := true
+		for i, f := range idx.Fields {
+			if f.Field == nil || f.DBName != columns[i] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return name
+		}
+	}
+	return g.db.NamingStrategy.IndexName(s.Table, strings.Join(columns, "_"))
+}
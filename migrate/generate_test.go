@@ -0,0 +1,124 @@
+package migrate
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shoyo10/wgorm/schemacheck"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+type genUser struct {
+	ID        int64
+	Name      string `gorm:"not null"`
+	Email     string `gorm:"uniqueIndex"`
+	Age       int    `gorm:"not null"`
+	Score     float64
+	Active    bool   `gorm:"not null;default:true"`
+	Token     string `gorm:"type:uuid;not null"`
+	Avatar    []byte `gorm:"not null"`
+	CreatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+type genOrder struct {
+	ID     int64
+	UserID int64   `gorm:"index:idx_orders_user_id"`
+	Code   string  `gorm:"uniqueIndex;size:32"`
+	Total  float64 `gorm:"not null"`
+}
+
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost user=app dbname=db"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name       string
+		mismatches []schemacheck.Mismatch
+	}{
+		{
+			name: "missing_table",
+			mismatches: []schemacheck.Mismatch{
+				{Kind: schemacheck.MissingTable, Table: "gen_orders"},
+			},
+		},
+		{
+			name: "add_columns",
+			mismatches: []schemacheck.Mismatch{
+				{Kind: schemacheck.MissingColumn, Table: "gen_users", Column: "name"},
+				{Kind: schemacheck.MissingColumn, Table: "gen_users", Column: "age"},
+				{Kind: schemacheck.MissingColumn, Table: "gen_users", Column: "score"},
+				{Kind: schemacheck.MissingColumn, Table: "gen_users", Column: "active"},
+				{Kind: schemacheck.MissingColumn, Table: "gen_users", Column: "token"},
+				{Kind: schemacheck.MissingColumn, Table: "gen_users", Column: "avatar"},
+				{Kind: schemacheck.MissingColumn, Table: "gen_users", Column: "created_at"},
+				{Kind: schemacheck.MissingColumn, Table: "gen_users", Column: "email"},
+				{Kind: schemacheck.MissingUniqueIndex, Table: "gen_users", Column: "email"},
+			},
+		},
+		{
+			name: "alter_columns",
+			mismatches: []schemacheck.Mismatch{
+				{Kind: schemacheck.TypeMismatch, Table: "gen_users", Column: "id", Expected: "bigint", Actual: "integer"},
+				{Kind: schemacheck.TypeMismatch, Table: "gen_users", Column: "score", Expected: "decimal", Actual: "text"},
+				{Kind: schemacheck.NullableMismatch, Table: "gen_users", Column: "name", Expected: "NOT NULL", Actual: "NULL"},
+				{Kind: schemacheck.NullableMismatch, Table: "gen_users", Column: "email", Expected: "NULL", Actual: "NOT NULL"},
+			},
+		},
+		{
+			name: "indexes",
+			mismatches: []schemacheck.Mismatch{
+				{Kind: schemacheck.MissingPrimaryIndex, Table: "gen_users"},
+				{Kind: schemacheck.MissingUniqueIndex, Table: "gen_users", Column: "email"},
+				{Kind: schemacheck.MissingUniqueIndex, Table: "gen_orders", Column: "user_id,code"},
+			},
+		},
+	}
+	db := dryRunDB(t)
+	models := []interface{}{&genUser{}, &genOrder{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := diff(db, models, schemacheck.Report{Mismatches: tt.mismatches})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := m.Format()
+			golden := filepath.Join("testdata", "diff_"+tt.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("migration =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+
+	if m, err := diff(db, models, schemacheck.Report{}); m != nil || err != nil {
+		t.Errorf("matching database diff = %+v, %v, want nil", m, err)
+	}
+	_, err := diff(db, models, schemacheck.Report{Mismatches: []schemacheck.Mismatch{{Kind: schemacheck.MissingColumn, Table: "gen_users", Column: "missing"}}})
+	if err == nil {
+		t.Error("column without field succeeded")
+	}
+}
@@ -0,0 +1,388 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTable is the version table goose uses
+const DefaultTable = "goose_db_version"
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+type Option func(m *Migrator) *Migrator
+
+// WithTable tracks the applied versions in table instead of goose_db_version
+func WithTable(table string) Option {
+	return func(m *Migrator) *Migrator {
+		m.table = table
+		return m
+	}
+}
+
+// WithLockKey sets the key of the postgres advisory lock held while migrating,
+// it defaults to a hash of the version table name
+func WithLockKey(key int64) Option {
+	return func(m *Migrator) *Migrator {
+		m.lockKey = key
+		m.lockKeySet = true
+		return m
+	}
+}
+
+// WithOutput writes a line for every migration applied or rolled back to w
+func WithOutput(w io.Writer) Option {
+	return func(m *Migrator) *Migrator {
+		m.out = w
+		return m
+	}
+}
+
+// WithDryRun writes the statements that would run to the output instead of running them
+func WithDryRun() Option {
+	return func(m *Migrator) *Migrator {
+		m.dryRun = true
+		return m
+	}
+}
+
+// Migrator applies goose annotated migrations, the runs of several processes are serialized
+// by a postgres advisory lock and every migration runs in its own transaction
+type Migrator struct {
+	db     *sql.DB
+	source Source
+
+	table      string
+	lockKey    int64
+	lockKeySet bool
+	out        io.Writer
+	dryRun     bool
+}
+
+// Status is the state of one migration
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// New returns a Migrator running the migrations of source on db
+func New(db *sql.DB, source Source, opts ...Option) *Migrator {
+	m := &Migrator{
+		db:     db,
+		source: source,
+		table:  DefaultTable,
+		out:    ioutil.Discard,
+	}
+	for _, opt := range opts {
+		m = opt(m)
+	}
+	if !m.lockKeySet {
+		h := fnv.New64a()
+		h.Write([]byte("wgorm/migrate:" + m.table))
+		m.lockKey = int64(h.Sum64())
+	}
+	return m
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.run(ctx, func(ctx context.Context, conn *sql.Conn, migrations []*Migration, applied map[int64]time.Time) error {
+		for _, mig := range migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the latest applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.run(ctx, func(ctx context.Context, conn *sql.Conn, migrations []*Migration, applied map[int64]time.Time) error {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[migrations[i].Version]; ok {
+				return m.apply(ctx, conn, migrations[i], false)
+			}
+		}
+		return errors.WithStack(fmt.Errorf("no applied migration to roll back"))
+	})
+}
+
+// To migrates up or down until version is the latest applied migration,
+// version 0 rolls back every migration
+func (m *Migrator) To(ctx context.Context, version int64) error {
+	return m.run(ctx, func(ctx context.Context, conn *sql.Conn, migrations []*Migration, applied map[int64]time.Time) error {
+		if version != 0 && !hasVersion(migrations, version) {
+			return errors.WithStack(fmt.Errorf("migration version %d not found", version))
+		}
+		for i := len(migrations) - 1; i >= 0; i-- {
+			mig := migrations[i]
+			if _, ok := applied[mig.Version]; ok && mig.Version > version {
+				if err := m.apply(ctx, conn, mig, false); err != nil {
+					return err
+				}
+			}
+		}
+		for _, mig := range migrations {
+			if _, ok := applied[mig.Version]; !ok && mig.Version <= version {
+				if err := m.apply(ctx, conn, mig, true); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Status returns every migration of the source with whether it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	migrations, err := Load(m.source)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkTable(); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]time.Time)
+	exists, err := m.tableExists(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if applied, err = m.applied(ctx, m.db); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	for _, mig := range migrations {
+		s := Status{
+			Version: mig.Version,
+			Name:    mig.Name,
+		}
+		if at, ok := applied[mig.Version]; ok {
+			s.Applied = true
+			at := at
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+type runFunc func(ctx context.Context, conn *sql.Conn, migrations []*Migration, applied map[int64]time.Time) error
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// run holds the advisory lock on a dedicated connection while fn migrates
+func (m *Migrator) run(ctx context.Context, fn runFunc) error {
+	migrations, err := Load(m.source)
+	if err != nil {
+		return err
+	}
+	if err := m.checkTable(); err != nil {
+		return err
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("migrate: %v", err))
+	}
+	defer conn.Close()
+
+	if !m.dryRun {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", m.lockKey); err != nil {
+			return errors.WithStack(fmt.Errorf("migrate: acquire lock: %v", err))
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", m.lockKey)
+	}
+
+	applied := make(map[int64]time.Time)
+	exists, err := m.tableExists(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if applied, err = m.applied(ctx, conn); err != nil {
+			return err
+		}
+	} else if err := m.createTable(ctx, conn); err != nil {
+		return err
+	}
+
+	return fn(ctx, conn, migrations, applied)
+}
+
+func (m *Migrator) checkTable() error {
+	if !tableName.MatchString(m.table) {
+		return errors.WithStack(fmt.Errorf("invalid version table name %q", m.table))
+	}
+	return nil
+}
+
+func (m *Migrator) tableExists(ctx context.Context, q queryer) (bool, error) {
+	var name sql.NullString
+	if err := q.QueryRowContext(ctx, "SELECT to_regclass($1)::text", m.table).Scan(&name); err != nil {
+		return false, errors.WithStack(fmt.Errorf("migrate: check version table: %v", err))
+	}
+	return name.Valid, nil
+}
+
+// createTable creates the version table like goose does, including its initial version 0 row
+func (m *Migrator) createTable(ctx context.Context, conn *sql.Conn) error {
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE %s (
+	id serial NOT NULL,
+	version_id bigint NOT NULL,
+	is_applied boolean NOT NULL,
+	tstamp timestamp NULL DEFAULT now(),
+	PRIMARY KEY(id)
+)`, m.table),
+		fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES (0, true)", m.table),
+	}
+	if m.dryRun {
+		for _, stmt := range stmts {
+			fmt.Fprintf(m.out, "%s;\n", stmt)
+		}
+		return nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("migrate: create version table: %v", err))
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return errors.WithStack(fmt.Errorf("migrate: create version table: %v", err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.WithStack(fmt.Errorf("migrate: create version table: %v", err))
+	}
+	return nil
+}
+
+// applied returns the applied versions with when they were applied, the latest row of a version decides
+func (m *Migrator) applied(ctx context.Context, q queryer) (map[int64]time.Time, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("SELECT version_id, is_applied, tstamp FROM %s ORDER BY id DESC", m.table))
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("migrate: read versions: %v", err))
+	}
+	defer rows.Close()
+
+	seen := make(map[int64]bool)
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			version   int64
+			isApplied bool
+			tstamp    sql.NullTime
+		)
+		if err := rows.Scan(&version, &isApplied, &tstamp); err != nil {
+			return nil, errors.WithStack(fmt.Errorf("migrate: read versions: %v", err))
+		}
+		if seen[version] {
+			continue
+		}
+		seen[version] = true
+		if isApplied && version != 0 {
+			applied[version] = tstamp.Time
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("migrate: read versions: %v", err))
+	}
+	return applied, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// apply runs the up or down statements of mig and records the new version state
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig *Migration, up bool) error {
+	stmts, direction := mig.Up, "up"
+	record := fmt.Sprintf("INSERT INTO %s (version_id, is_applied) VALUES ($1, true)", m.table)
+	if !up {
+		stmts, direction = mig.Down, "down"
+		record = fmt.Sprintf("DELETE FROM %s WHERE version_id = $1", m.table)
+	}
+
+	if m.dryRun {
+		fmt.Fprintf(m.out, "-- %s %s\n", direction, mig.Name)
+		for _, stmt := range stmts {
+			fmt.Fprintf(m.out, "%s\n", ensureSemicolon(stmt))
+		}
+		fmt.Fprintf(m.out, "%s\n", ensureSemicolon(strings.Replace(record, "$1", fmt.Sprint(mig.Version), 1)))
+		return nil
+	}
+
+	start := time.Now()
+	var ex execer = conn
+	var tx *sql.Tx
+	if !mig.NoTx {
+		var err error
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			return errors.WithStack(fmt.Errorf("migrate %s %s: %v", direction, mig.Name, err))
+		}
+		ex = tx
+	}
+
+	for i, stmt := range stmts {
+		if _, err := ex.ExecContext(ctx, stmt); err != nil {
+			if tx != nil {
+				tx.Rollback()
+			}
+			return errors.WithStack(fmt.Errorf("migrate %s %s: statement %d: %v", direction, mig.Name, i+1, err))
+		}
+	}
+	if _, err := ex.ExecContext(ctx, record, mig.Version); err != nil {
+		if tx != nil {
+			tx.Rollback()
+		}
+		return errors.WithStack(fmt.Errorf("migrate %s %s: record version: %v", direction, mig.Name, err))
+	}
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return errors.WithStack(fmt.Errorf("migrate %s %s: %v", direction, mig.Name, err))
+		}
+	}
+
+	fmt.Fprintf(m.out, "OK   %s %s (%v)\n", direction, mig.Name, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func ensureSemicolon(stmt string) string {
+	if strings.HasSuffix(strings.TrimSpace(stmt), ";") {
+		return stmt
+	}
+	return stmt + ";"
+}
+
+func hasVersion(migrations []*Migration, version int64) bool {
+	for _, mig := range migrations {
+		if mig.Version == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	annotationPrefix = "-- +goose"
+
+	annotationUp             = "Up"
+	annotationDown           = "Down"
+	annotationStatementBegin = "StatementBegin"
+	annotationStatementEnd   = "StatementEnd"
+	annotationNoTransaction  = "NO TRANSACTION"
+)
+
+// Migration is one goose annotated sql file
+type Migration struct {
+	Version int64
+	// Name is the file name, e.g. 20210525202734_init.sql
+	Name string
+	Up   []string
+	Down []string
+	// NoTx is set by -- +goose NO TRANSACTION, the statements then run outside a transaction
+	NoTx bool
+}
+
+// parseVersion reads the version from the digits in front of the first underscore of name
+func parseVersion(name string) (int64, error) {
+	base := path.Base(name)
+	i := strings.Index(base, "_")
+	if i <= 0 {
+		return 0, errors.WithStack(fmt.Errorf("migration %s: name must look like <version>_<description>.sql", name))
+	}
+	v, err := strconv.ParseInt(base[:i], 10, 64)
+	if err != nil || v <= 0 {
+		return 0, errors.WithStack(fmt.Errorf("migration %s: invalid version %q", name, base[:i]))
+	}
+	return v, nil
+}
+
+// parseMigration splits a goose sql file into its up and down statements.
+// A statement ends at a line ending with a semicolon, or at StatementEnd inside a StatementBegin block.
+func parseMigration(name string, content []byte) (*Migration, error) {
+	version, err := parseVersion(name)
+	if err != nil {
+		return nil, err
+	}
+	m := &Migration{
+		Version: version,
+		Name:    path.Base(name),
+	}
+
+	var (
+		direction string
+		buf       bytes.Buffer
+		inBlock   bool
+	)
+	flush := func() {
+		stmt := strings.TrimSpace(buf.String())
+		buf.Reset()
+		if stmt == "" {
+			return
+		}
+		switch direction {
+		case annotationUp:
+			m.Up = append(m.Up, stmt)
+		case annotationDown:
+			m.Down = append(m.Down, stmt)
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, annotationPrefix) {
+			switch annotation := strings.TrimSpace(strings.TrimPrefix(trimmed, annotationPrefix)); annotation {
+			case annotationUp, annotationDown:
+				if inBlock {
+					return nil, errors.WithStack(fmt.Errorf("migration %s:%d: %s inside a StatementBegin block", name, lineNo, annotation))
+				}
+				flush()
+				direction = annotation
+			case annotationStatementBegin:
+				if inBlock {
+					return nil, errors.WithStack(fmt.Errorf("migration %s:%d: nested StatementBegin", name, lineNo))
+				}
+				flush()
+				inBlock = true
+			case annotationStatementEnd:
+				if !inBlock {
+					return nil, errors.WithStack(fmt.Errorf("migration %s:%d: StatementEnd without StatementBegin", name, lineNo))
+				}
+				inBlock = false
+				flush()
+			case annotationNoTransaction:
+				m.NoTx = true
+			default:
+				return nil, errors.WithStack(fmt.Errorf("migration %s:%d: unknown annotation %q", name, lineNo, annotation))
+			}
+			continue
+		}
+
+		if direction == "" {
+			continue
+		}
+		// comments between statements are dropped, those inside a statement are kept
+		if !inBlock && buf.Len() == 0 && (trimmed == "" || strings.HasPrefix(trimmed, "--")) {
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		if !inBlock && strings.HasSuffix(trimmed, ";") {
+			flush()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("migration %s: %v", name, err))
+	}
+	if inBlock {
+		return nil, errors.WithStack(fmt.Errorf("migration %s: StatementBegin without StatementEnd", name))
+	}
+	flush()
+
+	if direction == "" {
+		return nil, errors.WithStack(fmt.Errorf("migration %s: missing -- +goose Up annotation", name))
+	}
+	return m, nil
+}
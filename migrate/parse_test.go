@@ -0,0 +1,129 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    int64
+		wantErr bool
+	}{
+		{name: "20210525202734_init.sql", want: 20210525202734},
+		{name: "migrations/00001_create_users.sql", want: 1},
+		{name: "1_a_b_c.sql", want: 1},
+		{name: "init.sql", wantErr: true},
+		{name: "_init.sql", wantErr: true},
+		{name: "v1_init.sql", wantErr: true},
+		{name: "0_init.sql", wantErr: true},
+		{name: "-1_init.sql", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseVersion(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseVersion(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseVersion(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseMigration(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *Migration
+		wantErr bool
+	}{
+		{
+			name: "up and down",
+			content: `-- +goose Up
+CREATE TABLE users (id bigint);
+CREATE INDEX idx_users_id
+    ON users (id);
+
+-- +goose Down
+DROP TABLE users;
+`,
+			want: &Migration{
+				Up:   []string{"CREATE TABLE users (id bigint);", "CREATE INDEX idx_users_id\n    ON users (id);"},
+				Down: []string{"DROP TABLE users;"},
+			},
+		},
+		{
+			name: "comments between statements are dropped, inside are kept",
+			content: `-- leading comment before any annotation
+-- +goose Up
+-- create the table
+CREATE TABLE users (
+    -- the key
+    id bigint
+);
+`,
+			want: &Migration{
+				Up: []string{"CREATE TABLE users (\n    -- the key\n    id bigint\n);"},
+			},
+		},
+		{
+			name: "statement block keeps semicolons",
+			content: `-- +goose Up
+-- +goose StatementBegin
+CREATE FUNCTION touch() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at = now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+-- +goose Down
+DROP FUNCTION touch;
+`,
+			want: &Migration{
+				Up:   []string{"CREATE FUNCTION touch() RETURNS trigger AS $$\nBEGIN\n    NEW.updated_at = now();\n    RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql;"},
+				Down: []string{"DROP FUNCTION touch;"},
+			},
+		},
+		{
+			name: "no transaction and a statement without semicolon",
+			content: `-- +goose NO TRANSACTION
+-- +goose Up
+CREATE INDEX CONCURRENTLY idx ON users (id)
+`,
+			want: &Migration{
+				Up:   []string{"CREATE INDEX CONCURRENTLY idx ON users (id)"},
+				NoTx: true,
+			},
+		},
+		{
+			name:    "up only without statements",
+			content: "-- +goose Up\n",
+			want:    &Migration{},
+		},
+		{name: "missing up", content: "CREATE TABLE users (id bigint);\n", wantErr: true},
+		{name: "unknown annotation", content: "-- +goose Sideways\n", wantErr: true},
+		{name: "nested block", content: "-- +goose Up\n-- +goose StatementBegin\n-- +goose StatementBegin\n", wantErr: true},
+		{name: "end without begin", content: "-- +goose Up\n-- +goose StatementEnd\n", wantErr: true},
+		{name: "unterminated block", content: "-- +goose Up\n-- +goose StatementBegin\nSELECT 1;\n", wantErr: true},
+		{name: "direction inside block", content: "-- +goose Up\n-- +goose StatementBegin\n-- +goose Down\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMigration("00001_test.sql", []byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMigration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			tt.want.Version = 1
+			tt.want.Name = "00001_test.sql"
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMigration() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Source lists and reads migration files
+type Source interface {
+	// Names returns the .sql file names of the source
+	Names() ([]string, error)
+	Read(name string) ([]byte, error)
+}
+
+type dirSource struct {
+	dir string
+}
+
+// Dir reads the migrations from a directory
+func Dir(dir string) Source {
+	return &dirSource{
+		dir: dir,
+	}
+}
+
+func (s *dirSource) Names() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("read migration dir: %v", err))
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".sql") {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *dirSource) Read(name string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("read migration: %v", err))
+	}
+	return b, nil
+}
+
+// Load parses every migration of source, sorted by version
+func Load(source Source) ([]*Migration, error) {
+	names, err := source.Names()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]*Migration, 0, len(names))
+	seen := make(map[int64]string, len(names))
+	for _, name := range names {
+		content, err := source.Read(name)
+		if err != nil {
+			return nil, err
+		}
+		m, err := parseMigration(name, content)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[m.Version]; ok {
+			return nil, errors.WithStack(fmt.Errorf("duplicate migration version %d: %s and %s", m.Version, other, name))
+		}
+		seen[m.Version] = name
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
@@ -0,0 +1,48 @@
+//go:build go1.16
+// +build go1.16
+
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type fsSource struct {
+	fsys fs.FS
+	dir  string
+}
+
+// FS reads the migrations from dir of fsys, e.g. an embed.FS
+func FS(fsys fs.FS, dir string) Source {
+	return &fsSource{
+		fsys: fsys,
+		dir:  dir,
+	}
+}
+
+func (s *fsSource) Names() ([]string, error) {
+	entries, err := fs.ReadDir(s.fsys, s.dir)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("read migration dir: %v", err))
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *fsSource) Read(name string) ([]byte, error) {
+	b, err := fs.ReadFile(s.fsys, path.Join(s.dir, name))
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("read migration: %v", err))
+	}
+	return b, nil
+}
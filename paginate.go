@@ -0,0 +1,550 @@
+package wgorm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	paginateKey    = "wgorm:paginate"
+	paginateRunKey = "wgorm:paginate_run"
+	cursorKey      = "wgorm:cursor"
+	cursorRunKey   = "wgorm:cursor_run"
+
+	// paginateTotalColumn holds the window count added to paginated queries
+	paginateTotalColumn = "wgorm_total"
+)
+
+// ErrInvalidCursor is returned for cursor tokens that are malformed, were not signed with
+// the cursor_secret or were issued for another table or sort order
+var ErrInvalidCursor = errors.New("wgorm: invalid cursor")
+
+// Page is the position of an offset paginated query and the number of rows matching it
+type Page struct {
+	Number int
+	Size   int
+	// Total counts the rows matching the query without limit and offset. Past the last page
+	// no row carries the count and it is read by a COUNT query of its own.
+	Total int64
+}
+
+// Pages returns the number of pages of Size holding Total rows
+func (p Page) Pages() int {
+	if p.Size <= 0 {
+		return 0
+	}
+	return int((p.Total + int64(p.Size) - 1) / int64(p.Size))
+}
+
+// Paginate reads page number page, starting at 1, of size rows. When result is not nil it is set
+// to the page and its Total is read along with the rows by a COUNT(*) OVER() window, in the same
+// round-trip unless the page is past the last one. The query must read into a model or a struct, or a slice of them.
+// Paginate can not be combined with Cursor.
+func Paginate(page, size int, result *Page) Option {
+	return func(g *Gorm) *Gorm {
+		if page < 1 || size < 1 {
+			g = g.clone()
+			g.DB = g.DB.Session(&gorm.Session{})
+			g.DB.AddError(errors.WithStack(fmt.Errorf("paginate: page and size must be positive, got page %d size %d", page, size)))
+			return g
+		}
+		if result != nil {
+			*result = Page{Number: page, Size: size}
+		}
+		tx := g.DB.Limit(size).Offset((page-1)*size).Set(paginateKey, result)
+		return g.clone().setDB(tx)
+	}
+}
+
+// CursorPage holds the tokens of the pages around the rows read by Cursor, empty when there is no such page
+type CursorPage struct {
+	Next string
+	Prev string
+}
+
+type cursorOptions struct {
+	after   string
+	limit   int
+	orderBy []string
+	page    *CursorPage
+}
+
+// Cursor reads limit rows after the position of the cursor token after, the first rows when it is empty,
+// in the order of orderBy, columns of the model optionally followed by ASC or DESC, e.g. "created_at DESC".
+// The primary key is appended to the order when it is missing so every row has a distinct position.
+// The order columns must not be NULL.
+//
+// page is set to the tokens of the next and previous pages, opaque strings holding the sort keys of the
+// first or last row signed with the cursor_secret of the config. A token given for another table or order,
+// or altered, fails the statement with ErrInvalidCursor. The query must read into a slice of a model or a struct
+// and must not have an ORDER BY of its own nor be combined with Paginate.
+func Cursor(after string, limit int, page *CursorPage, orderBy ...string) Option {
+	return func(g *Gorm) *Gorm {
+		if limit < 1 {
+			g = g.clone()
+			g.DB = g.DB.Session(&gorm.Session{})
+			g.DB.AddError(errors.WithStack(fmt.Errorf("cursor: limit must be positive, got %d", limit)))
+			return g
+		}
+		if page != nil {
+			*page = CursorPage{}
+		}
+		tx := g.DB.Set(cursorKey, &cursorOptions{
+			after:   after,
+			limit:   limit,
+			orderBy: orderBy,
+			page:    page,
+		})
+		return g.clone().setDB(tx)
+	}
+}
+
+type pageHook struct {
+	conn *connection
+}
+
+func newPageHook(conn *connection) *pageHook {
+	return &pageHook{
+		conn: conn,
+	}
+}
+
+// paginate adds the window count to the select list of a Paginate query, query reads it
+func (h *pageHook) paginate(db *gorm.DB) {
+	v, ok := db.Get(paginateKey)
+	if !ok || db.Error != nil {
+		return
+	}
+	if _, ok := db.Get(cursorKey); ok {
+		db.AddError(errors.WithStack(fmt.Errorf("paginate: can not be combined with Cursor")))
+		return
+	}
+	result := v.(*Page)
+	stmt := db.Statement
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Struct:
+	default:
+		// e.g. Count, which keeps the pagination of the statement it runs on
+		return
+	}
+	if result == nil {
+		return
+	}
+	if stmt.SQL.Len() > 0 {
+		db.AddError(errors.WithStack(fmt.Errorf("paginate: the total of raw SQL can not be counted")))
+		return
+	}
+	if _, err := destSchema(db); err != nil {
+		db.AddError(errors.WithStack(fmt.Errorf("paginate: %v", err)))
+		return
+	}
+
+	callbacks.BuildQuerySQL(db)
+	if db.Error != nil {
+		return
+	}
+	run := &paginateRun{result: result}
+	run.countSQL, run.countVars = countSQL(stmt)
+	total := "COUNT(*) OVER() AS " + paginateTotalColumn
+	c := stmt.Clauses["SELECT"]
+	switch expr := c.Expression.(type) {
+	case clause.Select:
+		if len(expr.Columns) == 0 {
+			expr.Columns = []clause.Column{{Table: clause.CurrentTable, Name: "*", Raw: true}}
+		}
+		expr.Columns = append(expr.Columns, clause.Column{Name: total, Raw: true})
+		c.Expression = expr
+	case clause.Expr:
+		expr.SQL += ", " + total
+		c.Expression = expr
+	default:
+		db.AddError(errors.WithStack(fmt.Errorf("paginate: unsupported select %T", c.Expression)))
+		return
+	}
+	stmt.Clauses["SELECT"] = c
+	stmt.SQL.Reset()
+	stmt.Vars = nil
+	stmt.Build(stmt.BuildClauses...)
+	db.InstanceSet(paginateRunKey, run)
+}
+
+// paginateRun is the state of a Paginate query between paginate and query
+type paginateRun struct {
+	result    *Page
+	countSQL  string
+	countVars []interface{}
+}
+
+// countSQL builds the query counting the rows of stmt without its limit, offset, order and locking
+func countSQL(stmt *gorm.Statement) (string, []interface{}) {
+	saved := make(map[string]clause.Clause, 3)
+	for _, name := range []string{"ORDER BY", "LIMIT", "FOR"} {
+		if c, ok := stmt.Clauses[name]; ok {
+			saved[name] = c
+			delete(stmt.Clauses, name)
+		}
+	}
+	stmt.SQL.Reset()
+	stmt.Vars = nil
+	stmt.Build(stmt.BuildClauses...)
+	sql, vars := "SELECT COUNT(*) FROM ("+stmt.SQL.String()+") AS wgorm_page", stmt.Vars
+	for name, c := range saved {
+		stmt.Clauses[name] = c
+	}
+	return sql, vars
+}
+
+// query replaces gorm:query, the total of a Paginate query is read from the window count of its
+// first row before gorm scans the rows, which skips the column since no field maps to it
+func (h *pageHook) query(db *gorm.DB) {
+	v, ok := db.InstanceGet(paginateRunKey)
+	if !ok || db.Error != nil || db.DryRun {
+		callbacks.Query(db)
+		return
+	}
+	run := v.(*paginateRun)
+	result := run.result
+	stmt := db.Statement
+	rows, err := stmt.ConnPool.QueryContext(stmt.Context, stmt.SQL.String(), stmt.Vars...)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		gorm.Scan(rows, db, false)
+		// the connection of a transaction is busy until the rows are closed
+		rows.Close()
+		if db.Error == nil && result.Number > 1 {
+			row := stmt.ConnPool.QueryRowContext(stmt.Context, run.countSQL, run.countVars...)
+			if err := row.Scan(&result.Total); err != nil {
+				db.AddError(errors.WithMessage(err, "paginate: count past the last page"))
+			}
+		}
+		return
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	// not sql.RawBytes, the row could not be scanned again
+	var skip interface{}
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		if column == paginateTotalColumn {
+			values[i] = &result.Total
+		} else {
+			values[i] = &skip
+		}
+	}
+	if err := rows.Scan(values...); err != nil {
+		db.AddError(err)
+		return
+	}
+	// the row is scanned again, into the destination
+	gorm.Scan(rows, db, true)
+}
+
+// destSchema returns the schema the rows of the statement are scanned with,
+// gorm parses the destination when it is not the model
+func destSchema(db *gorm.DB) (*schema.Schema, error) {
+	stmt := db.Statement
+	t := stmt.ReflectValue.Type()
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if stmt.Schema != nil && t == stmt.Schema.ModelType {
+		return stmt.Schema, nil
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("rows must be read into structs, got %s", stmt.ReflectValue.Type())
+	}
+	parsed := &gorm.Statement{DB: db}
+	if err := parsed.Parse(stmt.Dest); err != nil {
+		return nil, err
+	}
+	return parsed.Schema, nil
+}
+
+// cursorKeyColumn is a column of the order of a Cursor query
+type cursorKeyColumn struct {
+	column clause.Column
+	field  *schema.Field
+	desc   bool
+}
+
+type cursorRun struct {
+	opts     *cursorOptions
+	table    string
+	keys     []cursorKeyColumn
+	order    string
+	backward bool
+}
+
+// cursorToken is the signed content of a cursor token
+type cursorToken struct {
+	Backward bool              `json:"b,omitempty"`
+	Table    string            `json:"t"`
+	Order    string            `json:"o"`
+	Keys     []json.RawMessage `json:"k"`
+}
+
+// cursor adds the keyset condition, order and limit of a Cursor query
+func (h *pageHook) cursor(db *gorm.DB) {
+	v, ok := db.Get(cursorKey)
+	if !ok || db.Error != nil {
+		return
+	}
+	opts := v.(*cursorOptions)
+	stmt := db.Statement
+	if stmt.SQL.Len() > 0 {
+		db.AddError(errors.WithStack(fmt.Errorf("cursor: raw SQL can not be paginated")))
+		return
+	}
+	if stmt.ReflectValue.Kind() != reflect.Slice {
+		db.AddError(errors.WithStack(fmt.Errorf("cursor: rows must be read into a slice, got %s", stmt.ReflectValue.Type())))
+		return
+	}
+	if _, ok := stmt.Clauses["ORDER BY"]; ok {
+		db.AddError(errors.WithStack(fmt.Errorf("cursor: the order is set by Cursor, remove the Order of the statement")))
+		return
+	}
+	secret := h.conn.config().CursorSecret
+	if secret == "" {
+		db.AddError(errors.WithStack(fmt.Errorf("cursor: cursor_secret is not configured")))
+		return
+	}
+	s, err := destSchema(db)
+	if err != nil {
+		db.AddError(errors.WithStack(fmt.Errorf("cursor: %v", err)))
+		return
+	}
+	keys, order, err := cursorKeys(s, opts.orderBy)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+
+	run := &cursorRun{
+		opts:  opts,
+		table: stmt.Table,
+		keys:  keys,
+		order: order,
+	}
+	if opts.after != "" {
+		values, backward, err := decodeCursor(secret, opts.after, run.table, order, keys)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		run.backward = backward
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{keysetCondition(keys, values, backward)}})
+	}
+
+	orderBy := clause.OrderBy{}
+	for _, k := range keys {
+		orderBy.Columns = append(orderBy.Columns, clause.OrderByColumn{Column: k.column, Desc: k.desc != run.backward})
+	}
+	stmt.AddClause(orderBy)
+	// one more row than asked for tells whether there is a page after it
+	stmt.AddClause(clause.Limit{Limit: opts.limit + 1})
+	db.InstanceSet(cursorRunKey, run)
+}
+
+// cursorEnd drops the extra row of a Cursor query, restores the order of a backward page
+// and sets the tokens of the pages around it
+func (h *pageHook) cursorEnd(db *gorm.DB) {
+	v, ok := db.InstanceGet(cursorRunKey)
+	if !ok || db.Error != nil {
+		return
+	}
+	run := v.(*cursorRun)
+	rows := db.Statement.ReflectValue
+	n := rows.Len()
+	more := n > run.opts.limit
+	if more {
+		n = run.opts.limit
+		rows.Set(rows.Slice(0, n))
+		db.RowsAffected = int64(n)
+	}
+	if run.backward {
+		swap := reflect.Swapper(rows.Interface())
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+	if run.opts.page == nil || n == 0 {
+		return
+	}
+
+	secret := h.conn.config().CursorSecret
+	var err error
+	page := run.opts.page
+	first, last := rows.Index(0), rows.Index(n-1)
+	if more || run.backward {
+		page.Next, err = encodeCursor(secret, run, last, false)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+	if !run.backward && run.opts.after != "" || run.backward && more {
+		page.Prev, err = encodeCursor(secret, run, first, true)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+}
+
+// cursorKeys parses the order of a Cursor query and appends the missing primary key columns to it,
+// order is its normalized text that cursor tokens are bound to
+func cursorKeys(s *schema.Schema, orderBy []string) ([]cursorKeyColumn, string, error) {
+	var keys []cursorKeyColumn
+	seen := make(map[string]bool)
+	for _, o := range orderBy {
+		parts := strings.Fields(o)
+		if len(parts) == 0 || len(parts) > 2 || !filterColumn.MatchString(parts[0]) {
+			return nil, "", errors.WithStack(fmt.Errorf("cursor: invalid order %q", o))
+		}
+		k := cursorKeyColumn{}
+		if len(parts) == 2 {
+			switch strings.ToUpper(parts[1]) {
+			case "ASC":
+			case "DESC":
+				k.desc = true
+			default:
+				return nil, "", errors.WithStack(fmt.Errorf("cursor: invalid order %q", o))
+			}
+		}
+		table, name := clause.CurrentTable, parts[0]
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			table, name = name[:i], name[i+1:]
+		}
+		k.field = s.LookUpField(name)
+		if k.field == nil || k.field.DBName == "" {
+			return nil, "", errors.WithStack(fmt.Errorf("cursor: order column %s is not a field of %s", name, s.Name))
+		}
+		k.column = clause.Column{Table: table, Name: k.field.DBName}
+		seen[k.field.DBName] = true
+		keys = append(keys, k)
+	}
+	for _, f := range s.PrimaryFields {
+		if !seen[f.DBName] {
+			keys = append(keys, cursorKeyColumn{
+				column: clause.Column{Table: clause.CurrentTable, Name: f.DBName},
+				field:  f,
+			})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, "", errors.WithStack(fmt.Errorf("cursor: %s has no primary key, give the order columns", s.Name))
+	}
+
+	order := make([]string, len(keys))
+	for i, k := range keys {
+		order[i] = k.field.DBName
+		if k.desc {
+			order[i] += " DESC"
+		}
+	}
+	return keys, strings.Join(order, ","), nil
+}
+
+// keysetCondition matches the rows after values in the order of keys, before them when backward:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) ...
+func keysetCondition(keys []cursorKeyColumn, values []interface{}, backward bool) clause.Expression {
+	ors := make([]clause.Expression, 0, len(keys))
+	for i, k := range keys {
+		ands := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, clause.Eq{Column: keys[j].column, Value: values[j]})
+		}
+		if k.desc != backward {
+			ands = append(ands, clause.Lt{Column: k.column, Value: values[i]})
+		} else {
+			ands = append(ands, clause.Gt{Column: k.column, Value: values[i]})
+		}
+		ors = append(ors, clause.And(ands...))
+	}
+	return clause.Or(ors...)
+}
+
+// encodeCursor returns the token of the page after row, before it when backward
+func encodeCursor(secret string, run *cursorRun, row reflect.Value, backward bool) (string, error) {
+	token := cursorToken{
+		Backward: backward,
+		Table:    run.table,
+		Order:    run.order,
+	}
+	for _, k := range run.keys {
+		value, _ := k.field.ValueOf(row)
+		b, err := json.Marshal(value)
+		if err != nil {
+			return "", errors.WithStack(fmt.Errorf("cursor: encode %s: %v", k.field.DBName, err))
+		}
+		token.Keys = append(token.Keys, b)
+	}
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(cursorSignature(secret, payload)), nil
+}
+
+// decodeCursor verifies a token and returns its key values as the types of the key fields
+func decodeCursor(secret, s, table, order string, keys []cursorKeyColumn) ([]interface{}, bool, error) {
+	enc := base64.RawURLEncoding
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return nil, false, errors.WithStack(ErrInvalidCursor)
+	}
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, false, errors.WithStack(ErrInvalidCursor)
+	}
+	sig, err := enc.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, cursorSignature(secret, payload)) {
+		return nil, false, errors.WithStack(ErrInvalidCursor)
+	}
+
+	var token cursorToken
+	if err := json.Unmarshal(payload, &token); err != nil {
+		return nil, false, errors.WithStack(ErrInvalidCursor)
+	}
+	if token.Table != table || token.Order != order || len(token.Keys) != len(keys) {
+		return nil, false, errors.WithStack(ErrInvalidCursor)
+	}
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		v := reflect.New(k.field.FieldType)
+		if err := json.Unmarshal(token.Keys[i], v.Interface()); err != nil {
+			return nil, false, errors.WithStack(ErrInvalidCursor)
+		}
+		values[i] = v.Elem().Interface()
+	}
+	return values, token.Backward, nil
+}
+
+func cursorSignature(secret string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,268 @@
+package wgorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+// testConn answers every query with its rows and records the last query
+type testConn struct {
+	columns []string
+	rows    [][]driver.Value
+	// results answer the queries starting with their key instead
+	results map[string]*testRows
+
+	query string
+	args  []interface{}
+	ctx   context.Context
+}
+
+func (c *testConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *testConn) Driver() driver.Driver                        { return nil }
+func (c *testConn) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (c *testConn) Close() error                                 { return nil }
+func (c *testConn) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (c *testConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.ctx = ctx
+	c.query = query
+	c.args = nil
+	for _, a := range args {
+		c.args = append(c.args, a.Value)
+	}
+	for prefix, r := range c.results {
+		if strings.HasPrefix(query, prefix) {
+			return &testRows{columns: r.columns, rows: r.rows}, nil
+		}
+	}
+	return &testRows{columns: c.columns, rows: c.rows}, nil
+}
+
+type testRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *testRows) Columns() []string { return r.columns }
+func (r *testRows) Close() error      { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// testGorm returns a Gorm with the wgorm callbacks running its queries on conn
+func testGorm(t *testing.T, cfg *Config, conn *testConn) *Gorm {
+	t.Helper()
+	sqlDB := sql.OpenDB(conn)
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               gormLogger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &connection{db: db, master: sqlDB, cfg: cfg}
+	if err := registerCallbacks(db, c); err != nil {
+		t.Fatal(err)
+	}
+	return &Gorm{conn: c}
+}
+
+type pageUser struct {
+	ID   int64
+	Name string
+}
+
+func TestPaginate(t *testing.T) {
+	conn := &testConn{}
+	g := testGorm(t, &Config{Driver: Postgres}, conn)
+	ctx := context.Background()
+
+	conn.columns = []string{"id", "name", paginateTotalColumn}
+	conn.rows = [][]driver.Value{{int64(3), "c", int64(5)}, {int64(4), "d", int64(5)}}
+	var page Page
+	var users []pageUser
+	if err := g.WithContext(ctx).Options(Paginate(2, 2, &page)).GormDB().Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	wantSQL := `SELECT "page_users".*,COUNT(*) OVER() AS wgorm_total FROM "page_users" LIMIT 2 OFFSET 2`
+	if conn.query != wantSQL {
+		t.Errorf("SQL = %s, want %s", conn.query, wantSQL)
+	}
+	if want := []pageUser{{ID: 3, Name: "c"}, {ID: 4, Name: "d"}}; !reflect.DeepEqual(users, want) {
+		t.Errorf("rows = %+v, want %+v", users, want)
+	}
+	if want := (Page{Number: 2, Size: 2, Total: 5}); page != want || page.Pages() != 3 {
+		t.Errorf("page = %+v with %d pages, want %+v with 3 pages", page, page.Pages(), want)
+	}
+
+	conn.rows = [][]driver.Value{{int64(1), "a", int64(1)}}
+	var user pageUser
+	if err := g.WithContext(ctx).Options(Paginate(1, 10, &page)).GormDB().Take(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "a" || page.Total != 1 {
+		t.Errorf("row = %+v and total %d, want a and 1", user, page.Total)
+	}
+
+	// past the last page the total is counted on its own
+	conn.rows = nil
+	conn.results = map[string]*testRows{"SELECT COUNT(*)": {columns: []string{"count"}, rows: [][]driver.Value{{int64(5)}}}}
+	err := g.WithContext(ctx).Options(Paginate(9, 10, &page), SetForUpdate()).GormDB().Where("name <> ?", "x").Order("name").Find(&users).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 || page.Total != 5 {
+		t.Errorf("past the last page got %d rows and total %d, want 0 and 5", len(users), page.Total)
+	}
+	wantSQL = `SELECT COUNT(*) FROM (SELECT * FROM "page_users" WHERE name <> $1) AS wgorm_page`
+	if conn.query != wantSQL || !reflect.DeepEqual(conn.args, []interface{}{"x"}) {
+		t.Errorf("count SQL = %s %v, want %s [x]", conn.query, conn.args, wantSQL)
+	}
+	conn.results = nil
+	conn.query = ""
+	if err := g.WithContext(ctx).Options(Paginate(1, 10, &page)).GormDB().Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 0 || strings.HasPrefix(conn.query, "SELECT COUNT") {
+		t.Errorf("empty first page total %d after %s, want 0 without a count", page.Total, conn.query)
+	}
+	conn.results = map[string]*testRows{"SELECT COUNT(*)": {columns: []string{"count"}, rows: [][]driver.Value{{int64(5)}}}}
+	err = g.WithContext(ctx).Options(Paginate(9, 10, &page)).GormDB().Take(&user).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Take past the last page error = %v, want gorm.ErrRecordNotFound", err)
+	}
+
+	if err := g.WithContext(ctx).Options(Paginate(0, 10, &page)).GormDB().Find(&users).Error; err == nil {
+		t.Error("page 0 succeeded")
+	}
+	err = g.WithContext(ctx).Options(Paginate(1, 10, nil), Cursor("", 10, nil)).GormDB().Find(&users).Error
+	if err == nil || !strings.Contains(err.Error(), "Cursor") {
+		t.Errorf("Paginate with Cursor error = %v", err)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	conn := &testConn{columns: []string{"id", "name"}}
+	g := testGorm(t, &Config{Driver: Postgres, CursorSecret: "0123456789abcdef0123456789abcdef"}, conn)
+	ctx := context.Background()
+	find := func(after string, page *CursorPage, orderBy ...string) ([]pageUser, error) {
+		var users []pageUser
+		err := g.WithContext(ctx).Options(Cursor(after, 2, page, orderBy...)).GormDB().Find(&users).Error
+		return users, err
+	}
+
+	// the first page, the extra row tells there is a next page
+	conn.rows = [][]driver.Value{{int64(1), "a"}, {int64(2), "b"}, {int64(3), "c"}}
+	var first CursorPage
+	users, err := find("", &first, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSQL := `SELECT * FROM "page_users" ORDER BY "page_users"."name","page_users"."id" LIMIT 3`
+	if conn.query != wantSQL {
+		t.Errorf("SQL = %s, want %s", conn.query, wantSQL)
+	}
+	if want := []pageUser{{1, "a"}, {2, "b"}}; !reflect.DeepEqual(users, want) {
+		t.Errorf("rows = %+v, want %+v", users, want)
+	}
+	if first.Next == "" || first.Prev != "" {
+		t.Fatalf("first page tokens = %+v, want only Next", first)
+	}
+
+	// the last page
+	conn.rows = [][]driver.Value{{int64(3), "c"}}
+	var last CursorPage
+	users, err = find(first.Next, &last, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSQL = `SELECT * FROM "page_users" WHERE ("page_users"."name" > $1 OR ("page_users"."name" = $2 AND "page_users"."id" > $3)) ORDER BY "page_users"."name","page_users"."id" LIMIT 3`
+	if conn.query != wantSQL {
+		t.Errorf("SQL = %s, want %s", conn.query, wantSQL)
+	}
+	if want := []interface{}{"b", "b", int64(2)}; !reflect.DeepEqual(conn.args, want) {
+		t.Errorf("args = %#v, want %#v", conn.args, want)
+	}
+	if len(users) != 1 || last.Next != "" || last.Prev == "" {
+		t.Fatalf("last page rows = %+v tokens = %+v, want 1 row and only Prev", users, last)
+	}
+
+	// back from the last page, the rows come in reverse order and are restored
+	conn.rows = [][]driver.Value{{int64(2), "b"}, {int64(1), "a"}}
+	var back CursorPage
+	users, err = find(last.Prev, &back, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSQL = `SELECT * FROM "page_users" WHERE ("page_users"."name" < $1 OR ("page_users"."name" = $2 AND "page_users"."id" < $3)) ORDER BY "page_users"."name" DESC,"page_users"."id" DESC LIMIT 3`
+	if conn.query != wantSQL {
+		t.Errorf("SQL = %s, want %s", conn.query, wantSQL)
+	}
+	if want := []interface{}{"c", "c", int64(3)}; !reflect.DeepEqual(conn.args, want) {
+		t.Errorf("args = %#v, want %#v", conn.args, want)
+	}
+	if want := []pageUser{{1, "a"}, {2, "b"}}; !reflect.DeepEqual(users, want) {
+		t.Errorf("rows = %+v, want %+v", users, want)
+	}
+	if back.Next == "" || back.Prev != "" {
+		t.Fatalf("backward page tokens = %+v, want only Next", back)
+	}
+
+	// rejected tokens
+	payload, sig := strings.Split(first.Next, ".")[0], strings.Split(first.Next, ".")[1]
+	tampered := []byte(payload)
+	tampered[len(tampered)/2] ^= 1
+	tests := []struct {
+		name    string
+		after   string
+		orderBy []string
+		table   string
+	}{
+		{name: "other order", after: first.Next, orderBy: []string{"name DESC"}},
+		{name: "other table", after: first.Next, orderBy: []string{"name"}, table: "page_admins"},
+		{name: "altered payload", after: string(tampered) + "." + sig, orderBy: []string{"name"}},
+		{name: "altered signature", after: payload + "." + sig[:len(sig)-2], orderBy: []string{"name"}},
+		{name: "not a token", after: "page=2", orderBy: []string{"name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var users []pageUser
+			db := g.WithContext(ctx).Options(Cursor(tt.after, 2, nil, tt.orderBy...)).GormDB()
+			if tt.table != "" {
+				db = db.Table(tt.table)
+			}
+			err := db.Find(&users).Error
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("error = %v, want ErrInvalidCursor", err)
+			}
+			if strings.Contains(err.Error(), "name") {
+				t.Errorf("error %q leaks the order", err)
+			}
+		})
+	}
+
+	if _, err := find("", nil, "missing"); err == nil {
+		t.Error("unknown order column succeeded")
+	}
+	var user pageUser
+	if err := g.WithContext(ctx).Options(Cursor("", 2, nil)).GormDB().Take(&user).Error; err == nil {
+		t.Error("Cursor into a struct succeeded")
+	}
+}
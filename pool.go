@@ -0,0 +1,210 @@
+package wgorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/pkg/errors"
+)
+
+const (
+	warmInterval = 10 * time.Second
+	warmTimeout  = 30 * time.Second
+)
+
+// poolSettings are the per connection pool settings database/sql can not express,
+// shared by every connector of a Gorm so a reload applies to all of them
+type poolSettings struct {
+	mu       sync.RWMutex
+	lifetime time.Duration
+	jitter   time.Duration
+}
+
+func newPoolSettings(cfg *Config) *poolSettings {
+	ps := &poolSettings{}
+	ps.set(cfg)
+	return ps
+}
+
+func (ps *poolSettings) set(cfg *Config) {
+	ps.mu.Lock()
+	ps.lifetime = time.Duration(cfg.ConnMaxLifeTimeSec) * time.Second
+	ps.jitter = time.Duration(cfg.ConnMaxLifeTimeJitterSec) * time.Second
+	ps.mu.Unlock()
+}
+
+// expiresAt spreads the end of life of connections created at the same time over the jitter window
+func (ps *poolSettings) expiresAt(created time.Time, factor float64) time.Time {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	if ps.jitter <= 0 || ps.lifetime <= 0 {
+		return time.Time{}
+	}
+	jitter := ps.jitter
+	if jitter > ps.lifetime {
+		jitter = ps.lifetime
+	}
+	return created.Add(ps.lifetime - time.Duration(factor*float64(jitter)))
+}
+
+// pooledConn retires itself from the pool once its jittered lifetime is over,
+// database/sql's own ConnMaxLifetime stays the upper bound
+type pooledConn struct {
+	*stdlib.Conn
+	settings *poolSettings
+	created  time.Time
+	factor   float64
+}
+
+func (c *pooledConn) expired() bool {
+	expiresAt := c.settings.expiresAt(c.created, c.factor)
+	return !expiresAt.IsZero() && time.Now().After(expiresAt)
+}
+
+// ResetSession implements driver.SessionResetter, called before the connection is reused
+func (c *pooledConn) ResetSession(ctx context.Context) error {
+	if c.expired() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// QueryContext implements driver.QueryerContext, the rows of a Row, Rows or Scan statement
+// release its timeout context when they are closed. A Scan with session variables outside
+// a transaction runs in one of its own, see scanInTx.
+func (c *pooledConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	release, _ := ctx.Value(rowsReleaseKey{}).(context.CancelFunc)
+	if vars, ok := ctx.Value(scanSessionVarsKey{}).([]sessionVar); ok {
+		rows, values, err := scanInTx(ctx, c.Conn, vars, query, args)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := rows.(*stdlib.Rows)
+		if !ok {
+			return nil, errors.WithStack(fmt.Errorf("unexpected rows %T", rows))
+		}
+		return &scannedRows{Rows: r, values: values, release: release}, nil
+	}
+
+	rows, err := c.Conn.QueryContext(ctx, query, args)
+	if err != nil || release == nil {
+		return rows, err
+	}
+	if r, ok := rows.(*stdlib.Rows); ok {
+		return &releaseRows{Rows: r, release: release}, nil
+	}
+	return rows, nil
+}
+
+type releaseRows struct {
+	*stdlib.Rows
+	release context.CancelFunc
+}
+
+func (r *releaseRows) Close() error {
+	err := r.Rows.Close()
+	r.release()
+	return err
+}
+
+// scannedRows serves the rows read by scanInTx, the column types come from the closed rows
+type scannedRows struct {
+	*stdlib.Rows
+	values  [][]driver.Value
+	release context.CancelFunc
+}
+
+func (r *scannedRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func (r *scannedRows) Close() error {
+	if r.release != nil {
+		r.release()
+	}
+	return nil
+}
+
+// IsValid implements driver.Validator, called before the connection goes back to the pool
+func (c *pooledConn) IsValid() bool {
+	return !c.expired()
+}
+
+// warm opens connections until db holds at least n of them
+func warm(ctx context.Context, db *sql.DB, n int) {
+	stats := db.Stats()
+	need := n - stats.InUse
+	if n <= stats.OpenConnections || need <= 0 {
+		return
+	}
+
+	conns := make([]*sql.Conn, 0, need)
+	for i := 0; i < need; i++ {
+		c, err := db.Conn(ctx)
+		if err != nil {
+			break
+		}
+		conns = append(conns, c)
+	}
+	// released connections stay in the pool as idle connections
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// keepWarm tops the master and slaves up to min_warm_conns until stop is closed
+func (conn *connection) keepWarm(stop <-chan struct{}) {
+	ticker := time.NewTicker(warmInterval)
+	defer ticker.Stop()
+
+	for {
+		conn.warmAll()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (conn *connection) warmAll() {
+	n := conn.config().MinWarmConns
+	if n <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), warmTimeout)
+	defer cancel()
+
+	dbs := []*sql.DB{conn.master}
+	for _, r := range conn.replicas.list() {
+		dbs = append(dbs, r.db)
+	}
+
+	var wg sync.WaitGroup
+	for _, db := range dbs {
+		wg.Add(1)
+		go func(db *sql.DB) {
+			defer wg.Done()
+			warm(ctx, db, n)
+		}(db)
+	}
+	wg.Wait()
+}
+
+func jitterFactor() float64 {
+	return rand.Float64()
+}
@@ -0,0 +1,42 @@
+package wgorm
+
+import "strings"
+
+const redactedValue = "******"
+
+// Redacted returns a copy of cfg safe to print: passwords, passwords inside dsn,
+// private keys given as PEM content and the cursor secret are masked
+func (cfg *Config) Redacted() *Config {
+	c := *cfg
+	if c.CursorSecret != "" {
+		c.CursorSecret = redactedValue
+	}
+	c.Master = cfg.Master.redacted()
+	c.Slave = make([]ConnConfig, 0, len(cfg.Slave))
+	for _, cc := range cfg.Slave {
+		c.Slave = append(c.Slave, cc.redacted())
+	}
+	return &c
+}
+
+func (cc ConnConfig) redacted() ConnConfig {
+	cc = cc.clone()
+	if cc.Password != "" {
+		cc.Password = redactedValue
+	}
+	if cc.DSN != "" {
+		params, err := parseDSN(cc.DSN)
+		switch {
+		case err != nil:
+			cc.DSN = redactedValue
+		case params["password"] != "":
+			params["password"] = redactedValue
+			cc.DSN = buildDSN(params)
+		}
+	}
+	if strings.Contains(cc.SSLKey, "-----BEGIN") {
+		cc.SSLKey = redactedValue
+	}
+	cc.connString = ""
+	return cc
+}
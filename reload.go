@@ -0,0 +1,100 @@
+package wgorm
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// Reload applies cfg without reconnecting: pool sizes and lifetime change in place, slaves are
+// added or removed, logger level and slow threshold take effect for the next statement.
+// Queries already running finish on their old connections. Changing the master is refused.
+// Every Gorm sharing the connection of the one returned by New sees the new config.
+func (g *Gorm) Reload(cfg *Config) error {
+	if g.cluster != nil {
+		return ErrShardKeyRequired
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	config, err := cfg.clone()
+	if err != nil {
+		return err
+	}
+	return g.conn.reload(config)
+}
+
+// WatchConfig reloads g whenever the file of opts changes, a config that fails to load or
+// apply is passed to onError and the current one is kept. The watch ends when stop is called or g is closed.
+func (g *Gorm) WatchConfig(opts LoadOptions, onError func(error)) (stop func(), err error) {
+	if g.cluster != nil {
+		return nil, ErrShardKeyRequired
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	file := filepath.Clean(opts.File)
+	// watch the directory, editors and kubernetes config maps replace the file rather than write it
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() { close(done) })
+	}
+	report := func(err error) {
+		if onError != nil {
+			onError(err)
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		target, _ := filepath.EvalSymlinks(file)
+		for {
+			select {
+			case <-done:
+				return
+			case <-g.conn.stop:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				current, _ := filepath.EvalSymlinks(file)
+				written := filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create) != 0
+				if !written && (current == "" || current == target) {
+					continue
+				}
+				target = current
+				cfg, _, err := LoadConfig(opts)
+				if err == nil {
+					err = g.Reload(cfg)
+				}
+				if err != nil {
+					report(err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				report(errors.WithStack(err))
+			}
+		}
+	}()
+	return stop, nil
+}
+
+// Close closes the master and slave connection pools
+func (g *Gorm) Close() error {
+	if g.cluster != nil {
+		return ErrShardKeyRequired
+	}
+	return g.conn.close()
+}
@@ -0,0 +1,122 @@
+package wgorm
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// replica is one slave database
+type replica struct {
+	// key identifies the replica across reloads
+	key      string
+	db       *sql.DB
+	inflight sync.WaitGroup
+}
+
+// replicaDrainTimeout bounds how long close waits for rows still being read, e.g. rows never closed by their caller
+const replicaDrainTimeout = 30 * time.Second
+
+// close waits for the calls that picked the replica before it was removed and for the rows they returned
+// to be closed, then closes it. Rows and transactions hold their connection until they are closed, so the
+// replica is drained once none of its connections is in use.
+func (r *replica) close() error {
+	r.inflight.Wait()
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(replicaDrainTimeout)
+	for r.db.Stats().InUse > 0 {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return r.db.Close()
+		}
+	}
+	return r.db.Close()
+}
+
+// replicaPool is the single connection pool dbresolver reads from, it spreads reads over
+// the current replicas and falls back to the master when there is none. Its replicas can be
+// swapped at runtime, which dbresolver itself does not support.
+type replicaPool struct {
+	master *sql.DB
+
+	mu       sync.RWMutex
+	replicas []*replica
+}
+
+func newReplicaPool(master *sql.DB, replicas []*replica) *replicaPool {
+	return &replicaPool{
+		master:   master,
+		replicas: replicas,
+	}
+}
+
+func (p *replicaPool) acquire() (*sql.DB, func()) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.replicas) == 0 {
+		return p.master, func() {}
+	}
+	r := p.replicas[rand.Intn(len(p.replicas))]
+	r.inflight.Add(1)
+	return r.db, r.inflight.Done
+}
+
+func (p *replicaPool) list() []*replica {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]*replica(nil), p.replicas...)
+}
+
+func (p *replicaPool) swap(replicas []*replica) {
+	p.mu.Lock()
+	p.replicas = replicas
+	p.mu.Unlock()
+}
+
+func (p *replicaPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	db, done := p.acquire()
+	defer done()
+	return db.PrepareContext(ctx, query)
+}
+
+func (p *replicaPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db, done := p.acquire()
+	defer done()
+	return db.ExecContext(ctx, query, args...)
+}
+
+// QueryContext and QueryRowContext release the replica once the query started, close then waits
+// for the returned rows to be closed before it closes the replica
+func (p *replicaPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	db, done := p.acquire()
+	defer done()
+	return db.QueryContext(ctx, query, args...)
+}
+
+func (p *replicaPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	db, done := p.acquire()
+	defer done()
+	return db.QueryRowContext(ctx, query, args...)
+}
+
+// replicaDialector hands the replica pool to dbresolver as its only replica
+type replicaDialector struct {
+	gorm.Dialector
+	pool *replicaPool
+}
+
+func (d replicaDialector) Initialize(db *gorm.DB) error {
+	if err := d.Dialector.Initialize(db); err != nil {
+		return err
+	}
+	db.ConnPool = d.pool
+	return nil
+}
@@ -0,0 +1,306 @@
+// Package repo is a reusable repository over a gorm model, the reflection based counterpart of the
+// repositories of examples/repolayer: the model is given once to New and every method takes the
+// wgorm options and a filter value
+package repo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/shoyo10/wgorm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Scoper is a filter narrowing the statement, e.g. the WhereCondUser of examples/repolayer
+type Scoper interface {
+	Scope(tx *gorm.DB) *gorm.DB
+}
+
+type Option func(r *Repo) *Repo
+
+// Immutable columns are never written by Update, the primary key always is
+func Immutable(columns ...string) Option {
+	return func(r *Repo) *Repo {
+		r.omit = append(r.omit, columns...)
+		return r
+	}
+}
+
+// ConflictOn sets the unique columns Upsert detects existing rows by, the primary key by default
+func ConflictOn(columns ...string) Option {
+	return func(r *Repo) *Repo {
+		r.conflict = columns
+		return r
+	}
+}
+
+// Repo runs the CRUD statements of one model
+type Repo struct {
+	db     *wgorm.Gorm
+	typ    reflect.Type
+	schema *schema.Schema
+
+	omit     []string
+	conflict []string
+	// deletedAt is the column of the gorm.DeletedAt field, empty when the model is not soft deleted
+	deletedAt string
+}
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// New returns the repository of model, e.g. &User{}. Filters accepted by its methods are
+// nil for every row, a Scoper, a func(*gorm.DB) *gorm.DB, a map of column values,
+// a model value whose non zero fields must match or a filter struct as built by wgorm.BuildFilter.
+func New(db *wgorm.Gorm, model interface{}, opts ...Option) (*Repo, error) {
+	typ := reflect.TypeOf(model)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, errors.WithStack(fmt.Errorf("repo: model must be a struct, got %T", model))
+	}
+
+	stmt := &gorm.Statement{DB: db.WithContext(context.Background()).GormDB()}
+	if err := stmt.Parse(reflect.New(typ).Interface()); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("repo: parse model %s: %v", typ, err))
+	}
+
+	r := &Repo{
+		db:     db,
+		typ:    typ,
+		schema: stmt.Schema,
+		omit:   append([]string{}, stmt.Schema.PrimaryFieldDBNames...),
+	}
+	for _, f := range stmt.Schema.Fields {
+		if f.FieldType == deletedAtType && f.DBName != "" {
+			r.deletedAt = f.DBName
+		}
+	}
+	for _, opt := range opts {
+		r = opt(r)
+	}
+	return r, nil
+}
+
+// WithDB returns a copy of r running on db, e.g. a transaction begun by db.Begin
+func (r *Repo) WithDB(db *wgorm.Gorm) *Repo {
+	c := *r
+	c.db = db
+	return &c
+}
+
+// Schema returns the parsed model
+func (r *Repo) Schema() *schema.Schema {
+	return r.schema
+}
+
+// Create inserts value, a pointer to a model or to a slice of models. Defaults generated by the database,
+// e.g. the primary key, are set on value.
+func (r *Repo) Create(ctx context.Context, value interface{}, opts ...wgorm.Option) error {
+	if err := r.check(value, wantPtr|wantOne|wantMany); err != nil {
+		return err
+	}
+	err := r.db.WithContext(ctx).Options(opts...).GormDB().Create(value).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Upsert inserts value, a pointer to a model or to a slice of models, or updates every column but
+// the primary key and created_at of the row it conflicts with on the ConflictOn columns.
+// A soft deleted row is restored.
+func (r *Repo) Upsert(ctx context.Context, value interface{}, opts ...wgorm.Option) error {
+	if err := r.check(value, wantPtr|wantOne|wantMany); err != nil {
+		return err
+	}
+	onConflict := clause.OnConflict{
+		UpdateAll: true,
+	}
+	for _, c := range r.conflict {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: c})
+	}
+	err := r.db.WithContext(ctx).Options(opts...).GormDB().Clauses(onConflict).Create(value).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Get reads the first row matching filter, by primary key order, into dest, a pointer to a model.
+// It returns gorm.ErrRecordNotFound, with a stack, when no row matches.
+func (r *Repo) Get(ctx context.Context, dest interface{}, filter interface{}, opts ...wgorm.Option) error {
+	if err := r.check(dest, wantPtr|wantOne); err != nil {
+		return err
+	}
+	tx, err := r.filter(r.db.WithContext(ctx).Options(opts...).GormDB(), filter)
+	if err != nil {
+		return err
+	}
+	if len(r.schema.PrimaryFields) > 0 {
+		tx = tx.First(dest)
+	} else {
+		tx = tx.Take(dest)
+	}
+	if tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	return nil
+}
+
+// List reads every row matching filter into dest, a pointer to a slice of models or model pointers
+func (r *Repo) List(ctx context.Context, dest interface{}, filter interface{}, opts ...wgorm.Option) error {
+	if err := r.check(dest, wantPtr|wantMany); err != nil {
+		return err
+	}
+	tx, err := r.filter(r.db.WithContext(ctx).Options(opts...).GormDB(), filter)
+	if err != nil {
+		return err
+	}
+	if err := tx.Find(dest).Error; err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Update writes values, a model whose non zero fields are written or a map of column values,
+// to the rows matching filter. Soft deleted rows, the primary key and the Immutable columns are left alone.
+// Unlike UpdateColumns it runs the update hooks of the model and sets updated_at, as UpdateUser
+// of examples/repolayer does.
+// It returns gorm.ErrMissingWhereClause when filter matches every row.
+func (r *Repo) Update(ctx context.Context, values interface{}, filter interface{}, opts ...wgorm.Option) error {
+	if _, ok := values.(map[string]interface{}); !ok {
+		if err := r.check(values, wantOne); err != nil {
+			return err
+		}
+	}
+	tx, err := r.filter(r.db.WithContext(ctx).Options(opts...).GormDB().Model(r.newModel()), filter)
+	if err != nil {
+		return err
+	}
+	if err := tx.Scopes(r.notDeleted).Omit(r.omit...).Updates(values).Error; err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Delete deletes the rows matching filter, soft deleting them when the model has a gorm.DeletedAt field.
+// It returns gorm.ErrMissingWhereClause when filter matches every row.
+func (r *Repo) Delete(ctx context.Context, filter interface{}, opts ...wgorm.Option) error {
+	tx, err := r.filter(r.db.WithContext(ctx).Options(opts...).GormDB(), filter)
+	if err != nil {
+		return err
+	}
+	if err := tx.Delete(r.newModel()).Error; err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Count returns the number of rows matching filter, the limit and offset of the options,
+// e.g. of wgorm.Paginate, are ignored
+func (r *Repo) Count(ctx context.Context, filter interface{}, opts ...wgorm.Option) (int64, error) {
+	tx, err := r.filter(r.db.WithContext(ctx).Options(opts...).GormDB().Model(r.newModel()), filter)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	if err := tx.Limit(-1).Offset(-1).Count(&count).Error; err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return count, nil
+}
+
+// Exists reports whether a row matches filter, reading at most one row
+func (r *Repo) Exists(ctx context.Context, filter interface{}, opts ...wgorm.Option) (bool, error) {
+	tx, err := r.filter(r.db.WithContext(ctx).Options(opts...).GormDB().Model(r.newModel()), filter)
+	if err != nil {
+		return false, err
+	}
+	var found []int
+	if err := tx.Select("1").Limit(1).Find(&found).Error; err != nil {
+		return false, errors.WithStack(err)
+	}
+	return len(found) > 0, nil
+}
+
+// notDeleted skips soft deleted rows, which gorm only does for queries and deletes.
+// It runs after the filter scopes and leaves a statement without conditions to be rejected.
+func (r *Repo) notDeleted(tx *gorm.DB) *gorm.DB {
+	if _, ok := tx.Statement.Clauses["WHERE"]; !ok || r.deletedAt == "" {
+		return tx
+	}
+	return tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: r.deletedAt}, Value: nil})
+}
+
+func (r *Repo) newModel() interface{} {
+	return reflect.New(r.typ).Interface()
+}
+
+// filter narrows tx to the rows matching filter
+func (r *Repo) filter(tx *gorm.DB, filter interface{}) (*gorm.DB, error) {
+	switch f := filter.(type) {
+	case nil:
+		return tx, nil
+	case Scoper:
+		return tx.Scopes(f.Scope), nil
+	case func(*gorm.DB) *gorm.DB:
+		return tx.Scopes(f), nil
+	case map[string]interface{}:
+		return tx.Where(f), nil
+	}
+	if err := r.check(filter, wantOne); err == nil {
+		return tx.Where(filter), nil
+	}
+	t := reflect.TypeOf(filter)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, errors.WithStack(fmt.Errorf("repo: unsupported filter %T", filter))
+	}
+	expr, err := wgorm.BuildFilter(filter)
+	if err != nil || expr == nil {
+		return tx, err
+	}
+	return tx.Where(expr), nil
+}
+
+const (
+	// wantPtr requires a pointer
+	wantPtr = 1 << iota
+	// wantOne accepts a model
+	wantOne
+	// wantMany accepts a slice of models or model pointers
+	wantMany
+)
+
+// check verifies v holds what want accepts of the model
+func (r *Repo) check(v interface{}, want int) error {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	} else if want&wantPtr != 0 {
+		return errors.WithStack(fmt.Errorf("repo: got %T, want a pointer", v))
+	}
+	if t == nil {
+		return errors.WithStack(fmt.Errorf("repo: got nil, want a %s", r.typ))
+	}
+	if want&wantOne != 0 && t == r.typ {
+		return nil
+	}
+	if want&wantMany != 0 && t.Kind() == reflect.Slice {
+		elem := t.Elem()
+		if elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem == r.typ {
+			return nil
+		}
+	}
+	return errors.WithStack(fmt.Errorf("repo: got %T, want a %s", v, r.typ))
+}
@@ -0,0 +1,129 @@
+package repo
+
+import (
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type testUser struct {
+	ID        int64
+	Name      string
+	Age       int
+	DeletedAt gorm.DeletedAt
+}
+
+type testUserScope struct {
+	name string
+}
+
+func (s testUserScope) Scope(tx *gorm.DB) *gorm.DB {
+	return tx.Where("name = ?", s.name)
+}
+
+type testUserFilter struct {
+	MinAge int `wgorm:"col:age;op:gte"`
+}
+
+// dryRunDB builds statements without a server
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost user=app dbname=db"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func testRepo(t *testing.T, db *gorm.DB) *Repo {
+	t.Helper()
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&testUser{}); err != nil {
+		t.Fatal(err)
+	}
+	return &Repo{typ: stmt.Schema.ModelType, schema: stmt.Schema}
+}
+
+func TestRepoFilter(t *testing.T) {
+	db := dryRunDB(t)
+	r := testRepo(t, db)
+	tests := []struct {
+		name    string
+		filter  interface{}
+		where   string
+		wantErr bool
+	}{
+		{name: "nil", filter: nil, where: ""},
+		{name: "scoper", filter: testUserScope{name: "bob"}, where: "name = $1"},
+		{
+			name:   "scope func",
+			filter: func(tx *gorm.DB) *gorm.DB { return tx.Where("age > ?", 3) },
+			where:  "age > $1",
+		},
+		{name: "map", filter: map[string]interface{}{"name": "bob"}, where: `"name" = $1`},
+		{name: "model", filter: testUser{Name: "bob"}, where: `"test_users"."name" = $1`},
+		{name: "model pointer", filter: &testUser{Age: 3}, where: `"test_users"."age" = $1`},
+		{name: "filter struct", filter: testUserFilter{MinAge: 18}, where: `"age" >= $1`},
+		{name: "empty filter struct", filter: &testUserFilter{}, where: ""},
+		{name: "unsupported", filter: "name = 'bob'", wantErr: true},
+		{name: "invalid filter struct", filter: struct {
+			Age int `wgorm:"op:over"`
+		}{Age: 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := r.filter(db.Session(&gorm.Session{}), tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var users []testUser
+			sql := tx.Find(&users).Statement.SQL.String()
+			want := `SELECT * FROM "test_users" WHERE `
+			if tt.where != "" {
+				want += tt.where + " AND "
+			}
+			want += `"test_users"."deleted_at" IS NULL`
+			if sql != want {
+				t.Errorf("filter() SQL = %s, want %s", sql, want)
+			}
+		})
+	}
+}
+
+func TestRepoCheck(t *testing.T) {
+	r := testRepo(t, dryRunDB(t))
+	var nilUser *testUser
+	tests := []struct {
+		name    string
+		v       interface{}
+		want    int
+		wantErr bool
+	}{
+		{name: "pointer to model", v: &testUser{}, want: wantPtr | wantOne},
+		{name: "model without pointer", v: testUser{}, want: wantOne},
+		{name: "model needs pointer", v: testUser{}, want: wantPtr | wantOne, wantErr: true},
+		{name: "nil pointer to model", v: nilUser, want: wantPtr | wantOne},
+		{name: "nil", v: nil, want: wantOne, wantErr: true},
+		{name: "slice of models", v: &[]testUser{}, want: wantPtr | wantMany},
+		{name: "slice of model pointers", v: &[]*testUser{}, want: wantPtr | wantMany},
+		{name: "slice not accepted", v: &[]testUser{}, want: wantPtr | wantOne, wantErr: true},
+		{name: "model not accepted", v: &testUser{}, want: wantPtr | wantMany, wantErr: true},
+		{name: "other model", v: &testUserFilter{}, want: wantPtr | wantOne | wantMany, wantErr: true},
+		{name: "slice of other model", v: &[]testUserFilter{}, want: wantPtr | wantMany, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.check(tt.v, tt.want)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("check(%T) error = %v, wantErr %v", tt.v, err, tt.wantErr)
+			}
+		})
+	}
+}
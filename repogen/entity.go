@@ -0,0 +1,260 @@
+// Package repogen generates the layered repository of examples/repolayer, a model struct, a repo struct
+// with sql.Null types, converters between them, a where condition with range and null filters and
+// CRUD methods taking wgorm options, from database tables or gorm models
+package repogen
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shoyo10/wgorm/internal/pgcatalog"
+	"github.com/shoyo10/wgorm/migrate"
+	"gorm.io/gorm"
+)
+
+// Entity is a table and the Go names generated for it
+type Entity struct {
+	// Name is the type name of the model and the repo struct, e.g. User for table users
+	Name   string
+	Table  string
+	Fields []Field
+}
+
+// Field is a column of an entity
+type Field struct {
+	Name   string
+	Column string
+	// Type is the Go type of the column without pointer, e.g. int64 or time.Time
+	Type string
+	// Nullable fields are pointers in the model and sql.Null types in the repo struct
+	Nullable bool
+	Primary  bool
+	// Unique is set for columns with a single column unique index or constraint
+	Unique bool
+}
+
+// softDelete reports whether f is the gorm soft delete column
+func (f Field) softDelete() bool {
+	return f.Column == "deleted_at" && f.Nullable && f.Type == "time.Time"
+}
+
+// autoTime reports whether gorm fills f on create or update
+func (f Field) autoTime() bool {
+	return (f.Column == "created_at" || f.Column == "updated_at") && f.Type == "time.Time"
+}
+
+// udtTypes maps postgres udt names to Go types, enums and other user defined types are strings
+var udtTypes = map[string]string{
+	"int2":        "int16",
+	"int4":        "int",
+	"int8":        "int64",
+	"float4":      "float32",
+	"float8":      "float64",
+	"numeric":     "float64",
+	"bool":        "bool",
+	"text":        "string",
+	"varchar":     "string",
+	"bpchar":      "string",
+	"citext":      "string",
+	"uuid":        "string",
+	"json":        "string",
+	"jsonb":       "string",
+	"xml":         "string",
+	"inet":        "string",
+	"cidr":        "string",
+	"macaddr":     "string",
+	"interval":    "string",
+	"time":        "string",
+	"timetz":      "string",
+	"date":        "time.Time",
+	"timestamp":   "time.Time",
+	"timestamptz": "time.Time",
+	"bytea":       "[]byte",
+}
+
+// FromTables reads the given tables of schema, every table but the goose version table when none is given.
+// An empty schema means the current one.
+func FromTables(ctx context.Context, q pgcatalog.Queryer, schema string, tables ...string) ([]*Entity, error) {
+	catalog, err := pgcatalog.Tables(ctx, q, schema)
+	if err != nil {
+		return nil, err
+	}
+	if len(tables) == 0 {
+		for name := range catalog {
+			if name != migrate.DefaultTable {
+				tables = append(tables, name)
+			}
+		}
+		sort.Strings(tables)
+	}
+
+	entities := make([]*Entity, 0, len(tables))
+	for _, name := range tables {
+		t, ok := catalog[name]
+		if !ok {
+			return nil, errors.WithStack(fmt.Errorf("table %s not found", name))
+		}
+		e, err := FromTable(t)
+		if err != nil {
+			return nil, err
+		}
+		entities = append(entities, e)
+	}
+	return entities, nil
+}
+
+// FromTable builds the entity of a catalog table
+func FromTable(t *pgcatalog.Table) (*Entity, error) {
+	name, err := goName(singular(t.Name))
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("table %s: %v", t.Name, err))
+	}
+	e := &Entity{
+		Name:  name,
+		Table: t.Name,
+	}
+
+	primary := make(map[string]bool)
+	unique := make(map[string]bool)
+	for _, idx := range t.Indexes {
+		switch {
+		case idx.Primary:
+			for _, c := range idx.Columns {
+				primary[c] = true
+			}
+		case idx.Unique && len(idx.Columns) == 1:
+			unique[idx.Columns[0]] = true
+		}
+	}
+
+	for _, c := range t.Columns {
+		typ, ok := udtTypes[c.UDTName]
+		if !ok && c.DataType == "USER-DEFINED" {
+			typ, ok = "string", true
+		}
+		if !ok {
+			return nil, errors.WithStack(fmt.Errorf("column %s.%s: unsupported type %s", t.Name, c.Name, c.UDTName))
+		}
+		fieldName, err := goName(c.Name)
+		if err != nil {
+			return nil, errors.WithStack(fmt.Errorf("column %s.%s: %v", t.Name, c.Name, err))
+		}
+		e.Fields = append(e.Fields, Field{
+			Name:     fieldName,
+			Column:   c.Name,
+			Type:     typ,
+			Nullable: c.Nullable && !primary[c.Name],
+			Primary:  primary[c.Name],
+			Unique:   unique[c.Name],
+		})
+	}
+	return e, e.check()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// FromModel builds the entity of an existing model struct, e.g. &model.User{}, to generate only its repo
+// with WithoutModels. db parses the model, e.g. g.WithContext(ctx).GormDB().
+// Fields must be basic types, time.Time or []byte, pointers to them for nullable columns.
+func FromModel(db *gorm.DB, model interface{}) (*Entity, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("parse model %T: %v", model, err))
+	}
+	s := stmt.Schema
+	e := &Entity{
+		Name:  s.Name,
+		Table: s.Table,
+	}
+
+	unique := make(map[string]bool)
+	for _, idx := range s.ParseIndexes() {
+		if idx.Class == "UNIQUE" && len(idx.Fields) == 1 && idx.Fields[0].Field != nil {
+			unique[idx.Fields[0].DBName] = true
+		}
+	}
+
+	for _, f := range s.Fields {
+		if f.DBName == "" {
+			continue
+		}
+		t, nullable := f.FieldType, false
+		if t.Kind() == reflect.Ptr {
+			t, nullable = t.Elem(), true
+		}
+		var typ string
+		switch {
+		case t == timeType:
+			typ = "time.Time"
+		case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 && t.PkgPath() == "" && !nullable:
+			typ = "[]byte"
+		case t.PkgPath() == "" && goTypes[t.Kind().String()].null != "":
+			typ = t.Kind().String()
+		default:
+			return nil, errors.WithStack(fmt.Errorf("model %s field %s: unsupported type %s", s.Name, f.Name, f.FieldType))
+		}
+		e.Fields = append(e.Fields, Field{
+			Name:     f.Name,
+			Column:   f.DBName,
+			Type:     typ,
+			Nullable: nullable && !f.PrimaryKey,
+			Primary:  f.PrimaryKey,
+			Unique:   f.Unique || unique[f.DBName],
+		})
+	}
+	return e, e.check()
+}
+
+// check rejects field names the generated code already uses
+func (e *Entity) check() error {
+	seen := make(map[string]bool)
+	for _, f := range e.Fields {
+		if f.Name == "TableName" || seen[f.Name] {
+			return errors.WithStack(fmt.Errorf("table %s: column %s gives the clashing field name %s", e.Table, f.Column, f.Name))
+		}
+		seen[f.Name] = true
+	}
+	return nil
+}
+
+var initialisms = map[string]string{
+	"acl": "ACL", "api": "API", "cpu": "CPU", "css": "CSS", "dns": "DNS", "html": "HTML", "http": "HTTP",
+	"https": "HTTPS", "id": "ID", "ip": "IP", "json": "JSON", "sql": "SQL", "ssh": "SSH", "tls": "TLS",
+	"ttl": "TTL", "ui": "UI", "uid": "UID", "uri": "URI", "url": "URL", "uuid": "UUID", "xml": "XML",
+}
+
+// goName turns a snake case name into an exported Go name, e.g. user_id into UserID
+func goName(s string) (string, error) {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return r == '_' || r == '-' || r == ' ' }) {
+		if up, ok := initialisms[part]; ok {
+			b.WriteString(up)
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	name := b.String()
+	if !token.IsIdentifier(name) {
+		return "", fmt.Errorf("%q is not a valid Go name", name)
+	}
+	return name, nil
+}
+
+// singular guesses the singular of an english table name, set Entity.Name when it guesses wrong
+func singular(s string) string {
+	switch {
+	case strings.HasSuffix(s, "ies") && len(s) > 3:
+		return s[:len(s)-3] + "y"
+	case strings.HasSuffix(s, "sses"), strings.HasSuffix(s, "xes"), strings.HasSuffix(s, "ches"), strings.HasSuffix(s, "shes"):
+		return s[:len(s)-2]
+	case strings.HasSuffix(s, "s") && !strings.HasSuffix(s, "ss") && !strings.HasSuffix(s, "us"):
+		return s[:len(s)-1]
+	}
+	return s
+}
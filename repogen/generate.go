@@ -0,0 +1,150 @@
+package repogen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// header starts every generated file, Write only overwrites files starting with it
+const header = "// Code generated by wgorm gen repo"
+
+// goType is how a Go type is held by the repo struct when its column is nullable
+type goType struct {
+	// null is the sql.Null type, empty when the type holds NULL itself
+	null string
+	// value is the field of the null type holding the value
+	value string
+	// cast converts the model value to the value field, empty when they have the same type
+	cast string
+	// ranged types get Gte and Lte filters
+	ranged bool
+}
+
+var goTypes = map[string]goType{
+	"int":       {null: "sql.NullInt32", value: "Int32", cast: "int32", ranged: true},
+	"int16":     {null: "sql.NullInt32", value: "Int32", cast: "int32", ranged: true},
+	"int32":     {null: "sql.NullInt32", value: "Int32", ranged: true},
+	"int64":     {null: "sql.NullInt64", value: "Int64", ranged: true},
+	"float32":   {null: "sql.NullFloat64", value: "Float64", cast: "float64", ranged: true},
+	"float64":   {null: "sql.NullFloat64", value: "Float64", ranged: true},
+	"bool":      {null: "sql.NullBool", value: "Bool"},
+	"string":    {null: "sql.NullString", value: "String"},
+	"time.Time": {null: "sql.NullTime", value: "Time", ranged: true},
+	"[]byte":    {},
+}
+
+type Option func(g *generator) *generator
+
+// WithRepoPackage names the repository package, repository by default
+func WithRepoPackage(name string) Option {
+	return func(g *generator) *generator {
+		g.repoPackage = name
+		return g
+	}
+}
+
+// WithoutModels only generates the repository, for entities built by FromModel of models that already exist
+func WithoutModels() Option {
+	return func(g *generator) *generator {
+		g.skipModels = true
+		return g
+	}
+}
+
+type generator struct {
+	modelImport  string
+	modelPackage string
+	repoPackage  string
+	skipModels   bool
+}
+
+// File is a generated source file
+type File struct {
+	// Path is relative to the output directory, e.g. repository/user_repo.go
+	Path    string
+	Content []byte
+}
+
+// Files generates a model file per entity in the model package, a repo file per entity and repo.go
+// holding IRepository in the repository package. modelImport is the import path of the model package,
+// its last element names the package and its directory.
+func Files(entities []*Entity, modelImport string, opts ...Option) ([]File, error) {
+	g := &generator{
+		modelImport:  modelImport,
+		modelPackage: path.Base(modelImport),
+		repoPackage:  "repository",
+	}
+	for _, opt := range opts {
+		g = opt(g)
+	}
+	for _, name := range []string{g.modelPackage, g.repoPackage} {
+		if !token.IsIdentifier(name) {
+			return nil, errors.WithStack(fmt.Errorf("invalid package name %q", name))
+		}
+	}
+
+	var files []File
+	for _, e := range entities {
+		file := snake(e.Name)
+		if !g.skipModels {
+			b, err := g.render(modelTemplate, g.modelData(e))
+			if err != nil {
+				return nil, errors.WithStack(fmt.Errorf("generate model %s: %v", e.Name, err))
+			}
+			files = append(files, File{Path: path.Join(g.modelPackage, file+".go"), Content: b})
+		}
+		b, err := g.render(repoTemplate, g.repoData(e))
+		if err != nil {
+			return nil, errors.WithStack(fmt.Errorf("generate repo %s: %v", e.Name, err))
+		}
+		files = append(files, File{Path: path.Join(g.repoPackage, file+"_repo.go"), Content: b})
+	}
+
+	b, err := g.render(rootTemplate, g.rootData(entities))
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("generate repo.go: %v", err))
+	}
+	files = append(files, File{Path: path.Join(g.repoPackage, "repo.go"), Content: b})
+	return files, nil
+}
+
+// Write writes files under dir, it writes nothing when one of them would overwrite a file that was not generated
+func Write(dir string, files []File) error {
+	for _, f := range files {
+		p := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if old, err := ioutil.ReadFile(p); err == nil && !bytes.HasPrefix(old, []byte(header)) {
+			return errors.WithStack(fmt.Errorf("%s exists and was not generated, refusing to overwrite it", p))
+		}
+	}
+	for _, f := range files {
+		p := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := ioutil.WriteFile(p, f.Content, 0644); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+func (g *generator) render(t *template.Template, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	b, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format: %v\n%s", err, buf.Bytes())
+	}
+	return b, nil
+}
@@ -0,0 +1,175 @@
+package repogen
+
+import (
+	"database/sql"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shoyo10/wgorm/internal/pgcatalog"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// accounts exercises the column types, nullability and indexes FromTable maps
+var accounts = &pgcatalog.Table{
+	Name: "accounts",
+	Columns: []pgcatalog.Column{
+		{Name: "id", DataType: "bigint", UDTName: "int8"},
+		{Name: "email", DataType: "character varying", UDTName: "varchar", MaxLength: 255},
+		{Name: "nickname", DataType: "text", UDTName: "text", Nullable: true},
+		{Name: "age", DataType: "integer", UDTName: "int4", Nullable: true},
+		{Name: "balance", DataType: "numeric", UDTName: "numeric"},
+		{Name: "verified", DataType: "boolean", UDTName: "bool", Nullable: true},
+		{Name: "status", DataType: "USER-DEFINED", UDTName: "account_status"},
+		{Name: "avatar", DataType: "bytea", UDTName: "bytea", Nullable: true},
+		{Name: "created_at", DataType: "timestamp with time zone", UDTName: "timestamptz"},
+		{Name: "updated_at", DataType: "timestamp with time zone", UDTName: "timestamptz"},
+		{Name: "deleted_at", DataType: "timestamp with time zone", UDTName: "timestamptz", Nullable: true},
+	},
+	Indexes: []pgcatalog.Index{
+		{Name: "accounts_pkey", Columns: []string{"id"}, Unique: true, Primary: true},
+		{Name: "accounts_email_key", Columns: []string{"email"}, Unique: true},
+	},
+}
+
+type Order struct {
+	ID        int64
+	Code      string `gorm:"uniqueIndex"`
+	Total     float64
+	Note      *string
+	PaidAt    *time.Time
+	CreatedAt time.Time
+}
+
+func testEntities(t *testing.T) []*Entity {
+	t.Helper()
+	account, err := FromTable(accounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: &sql.DB{}}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := FromModel(db, &Order{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*Entity{account, order}
+}
+
+const modelImport = "github.com/shoyo10/wgorm/repogen/testdata/gen/model"
+
+func TestFilesGolden(t *testing.T) {
+	files, err := Files(testEntities(t), modelImport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+		golden := filepath.Join("testdata", strings.NewReplacer("/", "_", ".go", ".golden").Replace(f.Path))
+		if *update {
+			if err := ioutil.WriteFile(golden, f.Content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(f.Content) != string(want) {
+			t.Errorf("%s =\n%s\nwant\n%s", f.Path, f.Content, want)
+		}
+	}
+	want := "model/account.go repository/account_repo.go model/order.go repository/order_repo.go repository/repo.go"
+	if got := strings.Join(paths, " "); got != want {
+		t.Errorf("files = %s, want %s", got, want)
+	}
+
+	files, err = Files(testEntities(t), modelImport, WithoutModels(), WithRepoPackage("store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths = nil
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	if got, want := strings.Join(paths, " "), "store/account_repo.go store/order_repo.go store/repo.go"; got != want {
+		t.Errorf("files without models = %s, want %s", got, want)
+	}
+}
+
+// TestFilesBuild builds the generated packages inside the module so they can import wgorm
+func TestFilesBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated code with the go command")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	files, err := Files(testEntities(t), modelImport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join("testdata", "gen")
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	if err := Write(dir, files); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(goBin, "vet", "./testdata/gen/model", "./testdata/gen/repository")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated code does not build: %v\n%s", err, out)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repogen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files, err := Files(testEntities(t), modelImport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Write(dir, files); err != nil {
+		t.Fatal(err)
+	}
+	// generated files are overwritten
+	if err := Write(dir, files); err != nil {
+		t.Fatalf("rewrite of generated files: %v", err)
+	}
+
+	// a hand written file is kept and nothing is written
+	hand := filepath.Join(dir, "repository", "repo.go")
+	if err := ioutil.WriteFile(hand, []byte("package repository\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	model := filepath.Join(dir, "model", "account.go")
+	if err := os.Remove(model); err != nil {
+		t.Fatal(err)
+	}
+	err = Write(dir, files)
+	if err == nil || !strings.Contains(err.Error(), "refusing to overwrite") {
+		t.Fatalf("overwrite of a hand written file error = %v", err)
+	}
+	if b, _ := ioutil.ReadFile(hand); string(b) != "package repository\n" {
+		t.Errorf("hand written file was overwritten with\n%s", b)
+	}
+	if _, err := os.Stat(model); !os.IsNotExist(err) {
+		t.Errorf("Write wrote %s before refusing, stat error = %v", model, err)
+	}
+}
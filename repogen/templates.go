@@ -0,0 +1,506 @@
+package repogen
+
+import (
+	"fmt"
+	"go/token"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+var plainColumn = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// reservedWords are the postgres reserved words likely to name a column
+var reservedWords = map[string]bool{
+	"all": true, "and": true, "any": true, "array": true, "as": true, "asc": true, "both": true, "case": true,
+	"cast": true, "check": true, "collate": true, "column": true, "constraint": true, "create": true,
+	"default": true, "desc": true, "distinct": true, "do": true, "else": true, "end": true, "except": true,
+	"false": true, "for": true, "foreign": true, "from": true, "grant": true, "group": true, "having": true,
+	"in": true, "into": true, "leading": true, "limit": true, "not": true, "null": true, "offset": true,
+	"on": true, "only": true, "or": true, "order": true, "primary": true, "references": true, "select": true,
+	"some": true, "table": true, "then": true, "to": true, "trailing": true, "true": true, "union": true,
+	"unique": true, "user": true, "using": true, "when": true, "where": true, "with": true,
+}
+
+// sqlColumn quotes column for the sql strings of the where condition when it needs it
+func sqlColumn(column string) string {
+	if plainColumn.MatchString(column) && !reservedWords[column] {
+		return column
+	}
+	return `"` + strings.Replace(column, `"`, `""`, -1) + `"`
+}
+
+// snake turns a Go name into a file name, e.g. APIKey into api_key
+func snake(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// generatedNames are the identifiers of the generated methods a param must not shadow
+var generatedNames = map[string]bool{
+	"u": true, "r": true, "v": true, "i": true, "tx": true, "ctx": true, "opts": true, "err": true,
+	"condition": true, "req": true, "rows": true, "list": true, "sql": true, "time": true, "errors": true,
+	"wgorm": true, "gorm": true, "clause": true, "context": true,
+}
+
+// param names the model argument of the generated methods, e.g. user
+func param(name string) string {
+	runes := []rune(name)
+	i := 0
+	for i < len(runes) && unicode.IsUpper(runes[i]) {
+		i++
+	}
+	if i > 1 && i < len(runes) {
+		// keep the initial of the next word, e.g. APIKey gives apiKey
+		i--
+	}
+	p := strings.ToLower(string(runes[:i])) + string(runes[i:])
+	switch {
+	case token.IsKeyword(p), generatedNames[p]:
+		return p + "Model"
+	}
+	return p
+}
+
+type modelField struct {
+	Name   string
+	Type   string
+	Column string
+}
+
+type modelData struct {
+	Package string
+	Table   string
+	Name    string
+	Time    bool
+	Fields  []modelField
+}
+
+func (g *generator) modelData(e *Entity) modelData {
+	d := modelData{
+		Package: g.modelPackage,
+		Table:   e.Table,
+		Name:    e.Name,
+	}
+	for _, f := range e.Fields {
+		typ := f.Type
+		if f.Nullable && f.Type != "[]byte" {
+			typ = "*" + typ
+		}
+		if f.Type == "time.Time" {
+			d.Time = true
+		}
+		d.Fields = append(d.Fields, modelField{Name: f.Name, Type: typ, Column: f.Column})
+	}
+	return d
+}
+
+type repoField struct {
+	Name string
+	Type string
+	Tag  string
+}
+
+// filter is the where condition fields of one column
+type filter struct {
+	Field string
+	Type  string
+	// IsSet is the format of the check of a range value
+	IsSet   string
+	Gte     string
+	Lte     string
+	Null    string
+	NotNull string
+}
+
+type repoData struct {
+	Source       string
+	Package      string
+	ModelPackage string
+	ModelImport  string
+	Name         string
+	Table        string
+	Param        string
+	Fields       []repoField
+	ToRepo       []string
+	ToModel      []string
+	Filters      []filter
+	// Conflict is the unique column CreateX upserts soft deleted rows on
+	Conflict   string
+	SoftDelete string
+	Omit       string
+	Fetch      string
+
+	SQL, Time, Clause bool
+}
+
+func (g *generator) repoData(e *Entity) repoData {
+	d := repoData{
+		Source:       "table " + e.Table,
+		Package:      g.repoPackage,
+		ModelPackage: g.modelPackage,
+		ModelImport:  g.modelImport,
+		Name:         e.Name,
+		Table:        e.Table,
+		Param:        param(e.Name),
+		Fetch:        "Take",
+	}
+	if g.skipModels {
+		d.Source = "model " + g.modelPackage + "." + e.Name
+	}
+
+	var omit, unique []string
+	for _, f := range e.Fields {
+		t := goTypes[f.Type]
+		tag := "column:" + f.Column
+		if f.Primary {
+			tag += ";primaryKey"
+			omit = append(omit, f.Column)
+			d.Fetch = "First"
+		}
+		if f.Unique && !f.Primary {
+			omit = append(omit, f.Column)
+			unique = append(unique, f.Column)
+		}
+
+		typ := f.Type
+		switch {
+		case f.softDelete():
+			typ = "gorm.DeletedAt"
+			d.SoftDelete = f.Column
+			d.ToModel = append(d.ToModel, fmt.Sprintf("if u.%s.Valid {\nv := u.%s.Time\n%s.%s = &v\n}", f.Name, f.Name, d.Param, f.Name))
+		case f.Nullable && t.null != "":
+			typ = t.null
+			d.SQL = true
+			d.ToRepo = append(d.ToRepo, fmt.Sprintf("if %s.%s != nil {\nu.%s = %s{\n%s: %s,\nValid: true,\n}\n}",
+				d.Param, f.Name, f.Name, t.null, t.value, convert(t.cast, "*"+d.Param+"."+f.Name)))
+			back := f.Type
+			if t.cast == "" {
+				back = ""
+			}
+			d.ToModel = append(d.ToModel, fmt.Sprintf("if u.%s.Valid {\nv := %s\n%s.%s = &v\n}", f.Name, convert(back, "u."+f.Name+"."+t.value), d.Param, f.Name))
+		default:
+			if !f.autoTime() {
+				d.ToRepo = append(d.ToRepo, fmt.Sprintf("u.%s = %s.%s", f.Name, d.Param, f.Name))
+			}
+			d.ToModel = append(d.ToModel, fmt.Sprintf("%s.%s = u.%s", d.Param, f.Name, f.Name))
+		}
+		if typ == "time.Time" {
+			d.Time = true
+		}
+		d.Fields = append(d.Fields, repoField{Name: f.Name, Type: typ, Tag: fmt.Sprintf("`json:%q gorm:%q`", f.Column, tag)})
+
+		if f.Primary || f.softDelete() {
+			continue
+		}
+		column := sqlColumn(f.Column)
+		w := filter{Field: f.Name, Type: f.Type, IsSet: "w.%s != 0"}
+		if t.ranged {
+			if f.Type == "time.Time" {
+				w.IsSet = "!w.%s.IsZero()"
+				d.Time = true
+			}
+			w.Gte, w.Lte = fmt.Sprintf("%q", column+" >= ?"), fmt.Sprintf("%q", column+" <= ?")
+		}
+		if f.Nullable {
+			w.Null, w.NotNull = fmt.Sprintf("%q", column+" IS NULL"), fmt.Sprintf("%q", column+" IS NOT NULL")
+		}
+		if w.Gte != "" || w.Null != "" {
+			d.Filters = append(d.Filters, w)
+		}
+	}
+
+	if len(unique) == 1 && d.SoftDelete != "" {
+		d.Conflict = unique[0]
+		d.Clause = true
+	}
+	for i, c := range omit {
+		omit[i] = fmt.Sprintf("%q", c)
+	}
+	d.Omit = strings.Join(omit, ", ")
+	return d
+}
+
+func convert(cast, expr string) string {
+	if cast == "" {
+		return expr
+	}
+	return cast + "(" + expr + ")"
+}
+
+type rootData struct {
+	Package string
+	Names   []string
+}
+
+func (g *generator) rootData(entities []*Entity) rootData {
+	d := rootData{Package: g.repoPackage}
+	for _, e := range entities {
+		d.Names = append(d.Names, e.Name)
+	}
+	sort.Strings(d.Names)
+	return d
+}
+
+var modelTemplate = template.Must(template.New("model").Parse(header + ` from table {{.Table}}. DO NOT EDIT.
+
+package {{.Package}}
+
+{{if .Time}}import "time"{{end}}
+
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}} ` + "`" + `json:"{{.Column}}"` + "`" + `
+{{- end}}
+}
+`))
+
+var repoTemplate = template.Must(template.New("repo").Parse(header + ` from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+	{{- if .SQL}}
+	"database/sql"
+	{{- end}}
+	{{- if .Time}}
+	"time"
+	{{- end}}
+
+	"github.com/pkg/errors"
+	"github.com/shoyo10/wgorm"
+	"{{.ModelImport}}"
+	"gorm.io/gorm"
+	{{- if .Clause}}
+	"gorm.io/gorm/clause"
+	{{- end}}
+)
+
+type {{.Name}}Repo interface {
+	Create{{.Name}}(ctx context.Context, {{.Param}} {{.ModelPackage}}.{{.Name}}, opts ...wgorm.Option) error
+	Get{{.Name}}(ctx context.Context, condition WhereCond{{.Name}}, opts ...wgorm.Option) ({{.ModelPackage}}.{{.Name}}, error)
+	List{{.Name}}(ctx context.Context, condition WhereCond{{.Name}}, opts ...wgorm.Option) ([]{{.ModelPackage}}.{{.Name}}, error)
+	Update{{.Name}}(ctx context.Context, req Update{{.Name}}Req, opts ...wgorm.Option) error
+	Delete{{.Name}}(ctx context.Context, condition WhereCond{{.Name}}, opts ...wgorm.Option) error
+}
+
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}} {{.Tag}}
+{{- end}}
+}
+
+func ({{.Name}}) TableName() string {
+	return "{{.Table}}"
+}
+
+func (u *{{.Name}}) convertModelToRepo({{.Param}} {{.ModelPackage}}.{{.Name}}) {
+{{- range .ToRepo}}
+	{{.}}
+{{- end}}
+}
+
+func (u *{{.Name}}) convertRepoToModel({{.Param}} *{{.ModelPackage}}.{{.Name}}) {
+{{- range .ToModel}}
+	{{.}}
+{{- end}}
+}
+
+type WhereCond{{.Name}} struct {
+	{{.Name}} {{.ModelPackage}}.{{.Name}}
+{{- range .Filters}}
+{{- if .Gte}}
+	{{.Field}}Gte {{.Type}}
+	{{.Field}}Lte {{.Type}}
+{{- end}}
+{{- if .Null}}
+	{{.Field}}NULL    bool
+	{{.Field}}NotNULL bool
+{{- end}}
+{{- end}}
+}
+
+func (w WhereCond{{.Name}}) Scope(tx *gorm.DB) *gorm.DB {
+	tx = tx.Where(w.{{.Name}})
+{{- range .Filters}}
+{{- if .Gte}}
+	if {{printf .IsSet (printf "%sGte" .Field)}} {
+		tx = tx.Where({{.Gte}}, w.{{.Field}}Gte)
+	}
+	if {{printf .IsSet (printf "%sLte" .Field)}} {
+		tx = tx.Where({{.Lte}}, w.{{.Field}}Lte)
+	}
+{{- end}}
+{{- if .Null}}
+	if w.{{.Field}}NULL {
+		tx = tx.Where({{.Null}})
+	}
+	if w.{{.Field}}NotNULL {
+		tx = tx.Where({{.NotNull}})
+	}
+{{- end}}
+{{- end}}
+	return tx
+}
+
+type Update{{.Name}}Req struct {
+	{{.Name}} {{.ModelPackage}}.{{.Name}}
+	Where WhereCond{{.Name}}
+	// UpdateColumns updates these columns instead of the non zero fields of {{.Name}}
+	UpdateColumns map[string]interface{}
+}
+
+func (u *Update{{.Name}}Req) Scope(tx *gorm.DB) *gorm.DB {
+	tx = tx.Scopes(u.Where.Scope)
+	return tx
+}
+
+func (r *repo) Create{{.Name}}(ctx context.Context, {{.Param}} {{.ModelPackage}}.{{.Name}}, opts ...wgorm.Option) error {
+	var u {{.Name}}
+	u.convertModelToRepo({{.Param}})
+{{- if .Conflict}}
+	err := r.db.WithContext(ctx).Options(opts...).GormDB().Clauses(clause.OnConflict{
+		Columns: []clause.Column{
+			{
+				Name: "{{.Conflict}}",
+			},
+		},
+		Where: clause.Where{
+			Exprs: []clause.Expression{
+				clause.AndConditions{
+					Exprs: []clause.Expression{
+						clause.Expr{SQL: "{{.Table}}.{{.SoftDelete}} IS NOT NULL"},
+					},
+				},
+			},
+		},
+		UpdateAll: true,
+	}).Create(&u).Error
+{{- else}}
+	err := r.db.WithContext(ctx).Options(opts...).GormDB().Create(&u).Error
+{{- end}}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (r *repo) Get{{.Name}}(ctx context.Context, condition WhereCond{{.Name}}, opts ...wgorm.Option) ({{.ModelPackage}}.{{.Name}}, error) {
+	var u {{.Name}}
+	var {{.Param}} {{.ModelPackage}}.{{.Name}}
+
+	err := r.db.WithContext(ctx).Options(opts...).GormDB().Scopes(condition.Scope).{{.Fetch}}(&u).Error
+	if err != nil {
+		return {{.Param}}, errors.WithStack(err)
+	}
+	u.convertRepoToModel(&{{.Param}})
+
+	return {{.Param}}, nil
+}
+
+func (r *repo) List{{.Name}}(ctx context.Context, condition WhereCond{{.Name}}, opts ...wgorm.Option) ([]{{.ModelPackage}}.{{.Name}}, error) {
+	var rows []{{.Name}}
+	var list []{{.ModelPackage}}.{{.Name}}
+
+	err := r.db.WithContext(ctx).Options(opts...).GormDB().Scopes(condition.Scope).Find(&rows).Error
+	if err != nil {
+		return list, errors.WithStack(err)
+	}
+	for i := range rows {
+		var {{.Param}} {{.ModelPackage}}.{{.Name}}
+		rows[i].convertRepoToModel(&{{.Param}})
+		list = append(list, {{.Param}})
+	}
+	return list, nil
+}
+
+func (r *repo) Update{{.Name}}(ctx context.Context, req Update{{.Name}}Req, opts ...wgorm.Option) error {
+	tx := r.db.WithContext(ctx).Options(opts...).GormDB().Model(&{{.Name}}{}).Scopes(req.Scope){{if .Omit}}.Omit({{.Omit}}){{end}}
+	var err error
+	if len(req.UpdateColumns) > 0 {
+		err = tx.Updates(req.UpdateColumns).Error
+	} else {
+		var u {{.Name}}
+		u.convertModelToRepo(req.{{.Name}})
+		err = tx.Updates(&u).Error
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (r *repo) Delete{{.Name}}(ctx context.Context, condition WhereCond{{.Name}}, opts ...wgorm.Option) error {
+	err := r.db.WithContext(ctx).Options(opts...).GormDB().Scopes(condition.Scope).Delete(&{{.Name}}{}).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+`))
+
+var rootTemplate = template.Must(template.New("root").Parse(header + `. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shoyo10/wgorm"
+)
+
+type IRepository interface {
+	Transaction(ctx context.Context, fc func(txRepo IRepository) error) (err error)
+{{- range .Names}}
+	{{.}}Repo
+{{- end}}
+}
+
+type repo struct {
+	db *wgorm.Gorm
+}
+
+func New(db *wgorm.Gorm) IRepository {
+	return &repo{
+		db: db,
+	}
+}
+
+func (r *repo) Transaction(ctx context.Context, fc func(txRepo IRepository) error) (err error) {
+	panicked := true
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// Make sure to rollback when panic, Block error or Commit error
+		if panicked || err != nil {
+			if err := tx.Rollback(); err != nil {
+				log.Ctx(ctx).Error().Msgf("rollback failed: %+v", err)
+			}
+		}
+	}()
+
+	txRepo := &repo{db: tx}
+	err = fc(txRepo)
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	panicked = false
+	return
+}
+`))
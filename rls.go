@@ -0,0 +1,302 @@
+package wgorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+const (
+	sessionVarsSettingKey = "wgorm:session_vars"
+	sessionVarsTxKey      = "wgorm:session_vars_tx"
+	sessionVarsScanKey    = "wgorm:session_vars_scan"
+)
+
+// ErrSessionVarsNeedTransaction is returned for Row and Rows statements that carry session variables
+// outside a transaction, their rows outlive the callbacks so they can not be wrapped in one
+var ErrSessionVarsNeedTransaction = errors.New("wgorm: row statements with session variables must run in a transaction")
+
+type sessionVarsCtxKey struct{}
+
+// scanSessionVarsKey holds the session variables of a Scan run outside a transaction,
+// pooledConn runs its query in a transaction of its own and reads the rows before committing
+type scanSessionVarsKey struct{}
+
+// WithSessionVar sets the postgres setting name, e.g. app.user_id, for every transaction run with ctx.
+// Statements run outside a transaction are wrapped in one, Scan included. Row and Rows return rows
+// that outlive the statement and fail with ErrSessionVarsNeedTransaction outside a transaction.
+func WithSessionVar(ctx context.Context, name, value string) context.Context {
+	vars := make(map[string]string)
+	if parent, ok := ctx.Value(sessionVarsCtxKey{}).(map[string]string); ok {
+		for k, v := range parent {
+			vars[k] = v
+		}
+	}
+	vars[name] = value
+	return context.WithValue(ctx, sessionVarsCtxKey{}, vars)
+}
+
+type sessionVar struct {
+	name  string
+	value string
+}
+
+// sessionVars returns the settings to apply for ctx sorted by name,
+// the tenant of WithTenant is set as TenantSessionVar when configured
+func (cfg *Config) sessionVars(ctx context.Context) ([]sessionVar, error) {
+	if ctx == nil {
+		return nil, nil
+	}
+
+	vars := make(map[string]string)
+	if m, ok := ctx.Value(sessionVarsCtxKey{}).(map[string]string); ok {
+		for k, v := range m {
+			vars[k] = v
+		}
+	}
+	if cfg.TenantSessionVar != "" {
+		if tenantID, ok := TenantFromContext(ctx); ok {
+			vars[cfg.TenantSessionVar] = tenantID
+		}
+	}
+
+	out := make([]sessionVar, 0, len(vars))
+	for name, value := range vars {
+		if !validSessionVarName(name) {
+			return nil, errors.WithStack(fmt.Errorf("invalid session variable name %q, expect a prefixed name such as app.tenant_id", name))
+		}
+		out = append(out, sessionVar{name: name, value: value})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].name < out[j].name
+	})
+	return out, nil
+}
+
+// validSessionVarName accepts custom settings only, so built-in ones like role can not be switched from a context
+func validSessionVarName(name string) bool {
+	i := strings.Index(name, ".")
+	return i > 0 && i < len(name)-1 && !strings.ContainsAny(name, " '\";")
+}
+
+// setSessionVars applies vars to the transaction of pool, they end with it
+func setSessionVars(ctx context.Context, pool gorm.ConnPool, vars []sessionVar) error {
+	for _, v := range vars {
+		if _, err := pool.ExecContext(ctx, "SELECT set_config($1, $2, true)", v.name, v.value); err != nil {
+			return errors.WithMessagef(err, "set session variable %s", v.name)
+		}
+	}
+	return nil
+}
+
+type sessionVarsTx struct {
+	pool gorm.ConnPool
+	tx   *sql.Tx
+	done func()
+}
+
+type sessionVarsHook struct {
+	conn *connection
+}
+
+func newSessionVarsHook(conn *connection) *sessionVarsHook {
+	return &sessionVarsHook{
+		conn: conn,
+	}
+}
+
+// vars returns the session variables the statement still needs,
+// nil when it has none or runs in a transaction started by Begin
+func (h *sessionVarsHook) vars(db *gorm.DB) []sessionVar {
+	if db.Error != nil {
+		return nil
+	}
+	if _, ok := db.Get(sessionVarsSettingKey); ok {
+		return nil
+	}
+	vars, err := h.conn.config().sessionVars(db.Statement.Context)
+	if err != nil {
+		db.AddError(err)
+		return nil
+	}
+	return vars
+}
+
+// begin sets the session variables in the transaction of the statement,
+// starting one when the statement has none
+func (h *sessionVarsHook) begin(db *gorm.DB) {
+	vars := h.vars(db)
+	if len(vars) == 0 {
+		return
+	}
+
+	ctx := db.Statement.Context
+	pool := db.Statement.ConnPool
+	if _, ok := pool.(gorm.TxCommitter); ok {
+		db.AddError(setSessionVars(ctx, pool, vars))
+		return
+	}
+
+	// the statement timeout is released before end runs, the transaction must outlive it
+	txCtx := ctx
+	if v, ok := db.InstanceGet(timeoutCancelKey); ok {
+		if ts, ok := v.(*timeoutState); ok && ts != nil {
+			txCtx = ts.parent
+		}
+	}
+	state := &sessionVarsTx{
+		pool: pool,
+		done: func() {},
+	}
+	var err error
+	switch p := pool.(type) {
+	case *replicaPool:
+		var sqlDB *sql.DB
+		sqlDB, state.done = p.acquire()
+		state.tx, err = sqlDB.BeginTx(txCtx, nil)
+	case gorm.TxBeginner:
+		state.tx, err = p.BeginTx(txCtx, nil)
+	default:
+		err = gorm.ErrInvalidTransaction
+	}
+	if err != nil {
+		state.done()
+		db.AddError(errors.WithMessage(err, "begin transaction for session variables"))
+		return
+	}
+
+	db.Statement.ConnPool = state.tx
+	db.InstanceSet(sessionVarsTxKey, state)
+	if err := setSessionVars(ctx, state.tx, vars); err != nil {
+		db.AddError(err)
+	}
+}
+
+// end finishes the transaction started by begin, rolling it back when the statement failed
+func (h *sessionVarsHook) end(db *gorm.DB) {
+	v, ok := db.InstanceGet(sessionVarsTxKey)
+	if !ok {
+		return
+	}
+	state, ok := v.(*sessionVarsTx)
+	if !ok || state == nil {
+		return
+	}
+	db.InstanceSet(sessionVarsTxKey, (*sessionVarsTx)(nil))
+
+	if db.Error == nil {
+		db.AddError(state.tx.Commit())
+	} else {
+		state.tx.Rollback()
+	}
+	state.done()
+	db.Statement.ConnPool = state.pool
+}
+
+// row sets the session variables of row statements run in a transaction. Outside one it hands
+// those of a Scan, which reads its rows before returning, to the connection and refuses Row and Rows.
+func (h *sessionVarsHook) row(db *gorm.DB) {
+	vars := h.vars(db)
+	if len(vars) == 0 {
+		return
+	}
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		db.AddError(setSessionVars(db.Statement.Context, db.Statement.ConnPool, vars))
+		return
+	}
+	if !isScan(db) {
+		db.AddError(ErrSessionVarsNeedTransaction)
+		return
+	}
+	db.InstanceSet(sessionVarsScanKey, db.Statement.Context)
+	db.Statement.Context = context.WithValue(db.Statement.Context, scanSessionVarsKey{}, vars)
+}
+
+// rowEnd restores the context row replaced
+func (h *sessionVarsHook) rowEnd(db *gorm.DB) {
+	if v, ok := db.InstanceGet(sessionVarsScanKey); ok {
+		if ctx, ok := v.(context.Context); ok && ctx != nil {
+			db.Statement.Context = ctx
+			db.InstanceSet(sessionVarsScanKey, nil)
+		}
+	}
+}
+
+// scanLogger is the type of the logger gorm runs the Rows statement of a Scan with
+var scanLogger = reflect.TypeOf(gormLogger.Recorder.New())
+
+// isScan tells the row statement of a Scan from those of Row and Rows
+func isScan(db *gorm.DB) bool {
+	return reflect.TypeOf(db.Logger) == scanLogger
+}
+
+// scanInTx runs query in a transaction of its own with vars set and reads its rows in full before committing
+func scanInTx(ctx context.Context, conn txConn, vars []sessionVar, query string, args []driver.NamedValue) (driver.Rows, [][]driver.Value, error) {
+	tx, err := conn.BeginTx(ctx, driver.TxOptions{})
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "begin transaction for session variables")
+	}
+	rows, values, err := func() (driver.Rows, [][]driver.Value, error) {
+		for _, v := range vars {
+			args := []driver.NamedValue{{Ordinal: 1, Value: v.name}, {Ordinal: 2, Value: v.value}}
+			if _, err := conn.ExecContext(ctx, "SELECT set_config($1, $2, true)", args); err != nil {
+				return nil, nil, errors.WithMessagef(err, "set session variable %s", v.name)
+			}
+		}
+		rows, err := conn.QueryContext(ctx, query, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		values, err := readRows(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		return rows, values, nil
+	}()
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return rows, values, nil
+}
+
+type txConn interface {
+	driver.ConnBeginTx
+	driver.ExecerContext
+	driver.QueryerContext
+}
+
+// readRows reads and closes rows, the values are copied off the buffers of the driver
+func readRows(rows driver.Rows) ([][]driver.Value, error) {
+	var values [][]driver.Value
+	for {
+		row := make([]driver.Value, len(rows.Columns()))
+		err := rows.Next(row)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[i] = append([]byte(nil), b...)
+			}
+		}
+		values = append(values, row)
+	}
+	return values, rows.Close()
+}
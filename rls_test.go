@@ -0,0 +1,118 @@
+package wgorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// txTestConn is a testConn logging the transaction statements around its queries
+type txTestConn struct {
+	*testConn
+	log     []string
+	execErr error
+}
+
+func (c *txTestConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.log = append(c.log, "BEGIN")
+	return txTestTx{c}, nil
+}
+
+func (c *txTestConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.log = append(c.log, fmt.Sprintf("%s %v %v", query, args[0].Value, args[1].Value))
+	return driver.RowsAffected(1), c.execErr
+}
+
+type txTestTx struct {
+	c *txTestConn
+}
+
+func (tx txTestTx) Commit() error {
+	tx.c.log = append(tx.c.log, "COMMIT")
+	return nil
+}
+
+func (tx txTestTx) Rollback() error {
+	tx.c.log = append(tx.c.log, "ROLLBACK")
+	return nil
+}
+
+func TestScanInTx(t *testing.T) {
+	raw := []byte("raw")
+	conn := &txTestConn{testConn: &testConn{
+		columns: []string{"id", "data"},
+		rows:    [][]driver.Value{{int64(1), raw}, {int64(2), nil}},
+	}}
+	vars := []sessionVar{{name: "app.tenant_id", value: "t1"}, {name: "app.user_id", value: "u1"}}
+
+	_, values, err := scanInTx(context.Background(), conn, vars, "SELECT * FROM users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLog := []string{
+		"BEGIN",
+		"SELECT set_config($1, $2, true) app.tenant_id t1",
+		"SELECT set_config($1, $2, true) app.user_id u1",
+		"COMMIT",
+	}
+	if !reflect.DeepEqual(conn.log, wantLog) {
+		t.Errorf("statements = %q, want %q", conn.log, wantLog)
+	}
+	if conn.query != "SELECT * FROM users" {
+		t.Errorf("query = %s", conn.query)
+	}
+	raw[0] = 'X'
+	if want := [][]driver.Value{{int64(1), []byte("raw")}, {int64(2), nil}}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v read off the driver buffers", values, want)
+	}
+
+	conn.log = nil
+	conn.execErr = errors.New("boom")
+	if _, _, err := scanInTx(context.Background(), conn, vars, "SELECT * FROM users", nil); err == nil || !strings.Contains(err.Error(), "app.tenant_id") {
+		t.Errorf("error = %v, want the failed session variable", err)
+	}
+	if want := []string{"BEGIN", "SELECT set_config($1, $2, true) app.tenant_id t1", "ROLLBACK"}; !reflect.DeepEqual(conn.log, want) {
+		t.Errorf("statements = %q, want %q", conn.log, want)
+	}
+}
+
+func TestRowSessionVars(t *testing.T) {
+	conn := &testConn{columns: []string{"id", "name"}, rows: [][]driver.Value{{int64(1), "a"}}}
+	g := testGorm(t, &Config{Driver: Postgres, TenantSessionVar: "app.tenant_id"}, conn)
+	ctx := WithTenant(context.Background(), "t1")
+
+	var users []pageUser
+	tx := g.WithContext(ctx).GormDB().Raw("SELECT * FROM page_users").Scan(&users)
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	want := []sessionVar{{name: "app.tenant_id", value: "t1"}}
+	if got, _ := conn.ctx.Value(scanSessionVarsKey{}).([]sessionVar); !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan handed %v to the connection, want %v", got, want)
+	}
+	if len(users) != 1 || users[0].Name != "a" {
+		t.Errorf("rows = %+v", users)
+	}
+	if tx.Statement.Context.Value(scanSessionVarsKey{}) != nil {
+		t.Error("the session variables stayed on the statement context after Scan")
+	}
+
+	conn.ctx = nil
+	if _, err := g.WithContext(ctx).GormDB().Raw("SELECT * FROM page_users").Rows(); !errors.Is(err, ErrSessionVarsNeedTransaction) {
+		t.Errorf("Rows error = %v, want ErrSessionVarsNeedTransaction", err)
+	}
+	if conn.ctx != nil {
+		t.Error("Rows ran its query")
+	}
+
+	if err := g.WithContext(context.Background()).GormDB().Raw("SELECT * FROM page_users").Scan(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if conn.ctx.Value(scanSessionVarsKey{}) != nil {
+		t.Error("Scan without session variables handed some to the connection")
+	}
+}
@@ -0,0 +1,261 @@
+// Package schemacheck compares gorm models with the tables of the live database,
+// run it at startup or in CI to catch models drifting away from the migrations
+package schemacheck
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/shoyo10/wgorm/internal/pgcatalog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Kind is the kind of a mismatch
+type Kind string
+
+const (
+	MissingTable        Kind = "missing_table"
+	MissingColumn       Kind = "missing_column"
+	TypeMismatch        Kind = "type_mismatch"
+	NullableMismatch    Kind = "nullable_mismatch"
+	MissingUniqueIndex  Kind = "missing_unique_index"
+	MissingPrimaryIndex Kind = "missing_primary_key"
+)
+
+// Mismatch is one difference between a model and its table
+type Mismatch struct {
+	Kind   Kind
+	Model  string
+	Table  string
+	Column string
+	// Expected is what the model implies, Actual what the database has
+	Expected string
+	Actual   string
+}
+
+func (m Mismatch) String() string {
+	target := m.Table
+	if m.Column != "" {
+		target += "." + m.Column
+	}
+	s := fmt.Sprintf("%s %s (%s)", m.Kind, target, m.Model)
+	if m.Expected != "" || m.Actual != "" {
+		s += fmt.Sprintf(": expected %s, got %s", m.Expected, orNone(m.Actual))
+	}
+	return s
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// Report lists the mismatches of all checked models
+type Report struct {
+	Mismatches []Mismatch
+}
+
+// OK reports whether the models match the database
+func (r Report) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+// Err returns an error listing the mismatches, nil when there is none
+func (r Report) Err() error {
+	if r.OK() {
+		return nil
+	}
+	lines := make([]string, 0, len(r.Mismatches))
+	for _, m := range r.Mismatches {
+		lines = append(lines, m.String())
+	}
+	return errors.WithStack(fmt.Errorf("schema drift:\n  %s", strings.Join(lines, "\n  ")))
+}
+
+type Option func(c *checker) *checker
+
+// WithSchema checks the tables of schema instead of the current schema
+func WithSchema(name string) Option {
+	return func(c *checker) *checker {
+		c.schema = name
+		return c
+	}
+}
+
+type checker struct {
+	db     *gorm.DB
+	schema string
+	tables map[string]*pgcatalog.Table
+	report Report
+}
+
+// Check compares models, e.g. &repository.User{}, with the tables of db.
+// Pass g.WithContext(ctx).GormDB() to check a wgorm database, the catalog is read from the master.
+// It reports missing tables and columns, type and nullability differences and
+// missing unique indexes implied by unique, uniqueIndex and primaryKey tags.
+func Check(ctx context.Context, db *gorm.DB, models []interface{}, opts ...Option) (Report, error) {
+	c := &checker{
+		db: db.WithContext(ctx),
+	}
+	for _, opt := range opts {
+		c = opt(c)
+	}
+
+	tables, err := pgcatalog.Tables(ctx, db.ConnPool, c.schema)
+	if err != nil {
+		return Report{}, err
+	}
+	c.tables = tables
+
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: c.db}
+		if err := stmt.Parse(model); err != nil {
+			return Report{}, errors.WithStack(fmt.Errorf("parse model %T: %v", model, err))
+		}
+		c.checkModel(stmt.Schema)
+	}
+	return c.report, nil
+}
+
+func (c *checker) add(m Mismatch) {
+	c.report.Mismatches = append(c.report.Mismatches, m)
+}
+
+func (c *checker) checkModel(s *schema.Schema) {
+	table, ok := c.tables[s.Table]
+	if !ok {
+		c.add(Mismatch{Kind: MissingTable, Model: s.Name, Table: s.Table})
+		return
+	}
+
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.IgnoreMigration {
+			continue
+		}
+		column, ok := table.Column(field.DBName)
+		if !ok {
+			c.add(Mismatch{Kind: MissingColumn, Model: s.Name, Table: s.Table, Column: field.DBName, Expected: c.db.Dialector.DataTypeOf(field)})
+			continue
+		}
+		c.checkType(s, field, column)
+		c.checkNullable(s, field, column)
+	}
+
+	c.checkIndexes(s, table)
+}
+
+func (c *checker) checkType(s *schema.Schema, field *schema.Field, column pgcatalog.Column) {
+	if field.DataType == "" {
+		return
+	}
+	expected := c.db.Dialector.DataTypeOf(field)
+	expType, expLen := pgcatalog.NormalizeType(expected)
+	if expType == column.UDTName && (expLen == 0 || expLen == column.MaxLength) {
+		return
+	}
+	// a string without size or type tag does not imply text over varchar
+	if field.DataType == schema.String && field.Size == 0 && field.TagSettings["TYPE"] == "" && column.UDTName == "varchar" {
+		return
+	}
+	actual := column.UDTName
+	if column.MaxLength > 0 {
+		actual = fmt.Sprintf("%s(%d)", actual, column.MaxLength)
+	}
+	c.add(Mismatch{Kind: TypeMismatch, Model: s.Name, Table: s.Table, Column: field.DBName, Expected: expected, Actual: actual})
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// canHoldNull reports whether reading NULL into the field works
+func canHoldNull(field *schema.Field) bool {
+	switch field.FieldType.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	}
+	return reflect.PtrTo(field.FieldType).Implements(scannerType)
+}
+
+func (c *checker) checkNullable(s *schema.Schema, field *schema.Field, column pgcatalog.Column) {
+	mismatch := Mismatch{Kind: NullableMismatch, Model: s.Name, Table: s.Table, Column: field.DBName}
+	switch {
+	case column.Nullable && (field.NotNull || field.PrimaryKey):
+		mismatch.Expected, mismatch.Actual = "NOT NULL", "NULL"
+	case column.Nullable && !canHoldNull(field):
+		// scanning NULL into the field fails
+		mismatch.Expected, mismatch.Actual = "NOT NULL", "NULL"
+	case !column.Nullable && canHoldNull(field) && !field.NotNull && !field.PrimaryKey && !field.HasDefaultValue:
+		// writing the zero value of the field fails
+		mismatch.Expected, mismatch.Actual = "NULL", "NOT NULL"
+	default:
+		return
+	}
+	c.add(mismatch)
+}
+
+func (c *checker) checkIndexes(s *schema.Schema, table *pgcatalog.Table) {
+	if len(s.PrimaryFieldDBNames) > 0 && !hasIndex(table, s.PrimaryFieldDBNames, true) {
+		c.add(Mismatch{Kind: MissingPrimaryIndex, Model: s.Name, Table: s.Table, Expected: "PRIMARY KEY (" + strings.Join(s.PrimaryFieldDBNames, ", ") + ")"})
+	}
+
+	var unique [][]string
+	for _, field := range s.Fields {
+		if field.Unique && field.DBName != "" {
+			unique = append(unique, []string{field.DBName})
+		}
+	}
+	indexes := s.ParseIndexes()
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		idx := indexes[name]
+		if idx.Class != "UNIQUE" {
+			continue
+		}
+		var columns []string
+		for _, f := range idx.Fields {
+			if f.Field != nil {
+				columns = append(columns, f.DBName)
+			}
+		}
+		if len(columns) > 0 {
+			unique = append(unique, columns)
+		}
+	}
+
+	for _, columns := range unique {
+		if !hasIndex(table, columns, false) {
+			c.add(Mismatch{Kind: MissingUniqueIndex, Model: s.Name, Table: s.Table, Column: strings.Join(columns, ","), Expected: "UNIQUE (" + strings.Join(columns, ", ") + ")"})
+		}
+	}
+}
+
+// hasIndex looks for a unique index, or the primary key when primary is set, on exactly columns
+func hasIndex(table *pgcatalog.Table, columns []string, primary bool) bool {
+	for _, idx := range table.Indexes {
+		if !idx.Unique || (primary && !idx.Primary) || len(idx.Columns) != len(columns) {
+			continue
+		}
+		same := true
+		for i := range columns {
+			if idx.Columns[i] != columns[i] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return true
+		}
+	}
+	return false
+}
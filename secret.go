@@ -0,0 +1,198 @@
+package wgorm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPasswordTTL        = 5 * time.Minute
+	passwordRefreshTimeout    = 30 * time.Second
+	defaultRefreshBeforeRatio = 5
+)
+
+// SecretProvider resolves the database password, it is called before every new connection
+// so a rotated password is picked up without restarting.
+// Implementations should cache when looking the secret up is expensive.
+type SecretProvider interface {
+	Password(ctx context.Context) (string, error)
+}
+
+// SecretProviderFunc is a function implementing SecretProvider
+type SecretProviderFunc func(ctx context.Context) (string, error)
+
+// Password calls f(ctx)
+func (f SecretProviderFunc) Password(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+type fileSecret struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+// FileSecret reads the password from a file, the file is read again whenever it changes.
+// Surrounding whitespace is trimmed.
+func FileSecret(path string) SecretProvider {
+	return &fileSecret{
+		path: path,
+	}
+}
+
+func (s *fileSecret) Password(ctx context.Context) (string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("password file: %v", err))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.value, nil
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("password file: %v", err))
+	}
+	s.value = strings.TrimSpace(string(b))
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return s.value, nil
+}
+
+type envSecret struct {
+	name string
+}
+
+// EnvSecret reads the password from an environment variable
+func EnvSecret(name string) SecretProvider {
+	return &envSecret{
+		name: name,
+	}
+}
+
+func (s *envSecret) Password(ctx context.Context) (string, error) {
+	v, ok := os.LookupEnv(s.name)
+	if !ok {
+		return "", errors.WithStack(fmt.Errorf("password env %s is not set", s.name))
+	}
+	return v, nil
+}
+
+// secretProvider returns where the password comes from, nil means the static Password field
+func (cc *ConnConfig) secretProvider() SecretProvider {
+	switch {
+	case cc.SecretProvider != nil:
+		return cc.SecretProvider
+	case cc.PasswordFunc != nil:
+		return CachedSecret(cc.PasswordFunc, time.Duration(cc.PasswordTTLSec)*time.Second, time.Duration(cc.PasswordRefreshBeforeSec)*time.Second)
+	case cc.PasswordFile != "":
+		return FileSecret(cc.PasswordFile)
+	case cc.PasswordEnv != "":
+		return EnvSecret(cc.PasswordEnv)
+	}
+	return nil
+}
+
+type cachedSecret struct {
+	fn            func(ctx context.Context) (string, error)
+	ttl           time.Duration
+	refreshBefore time.Duration
+
+	fetchMu    sync.Mutex
+	mu         sync.Mutex
+	value      string
+	expiresAt  time.Time
+	refreshing bool
+}
+
+// CachedSecret wraps a short-lived password source such as an auth token callback.
+// A password is reused for ttl, within refreshBefore of its expiry it is refreshed in the background
+// while the cached one keeps being served, so new connections never wait on an expired token.
+// refreshBefore defaults to a fifth of ttl.
+func CachedSecret(fn func(ctx context.Context) (string, error), ttl, refreshBefore time.Duration) SecretProvider {
+	if ttl <= 0 {
+		ttl = defaultPasswordTTL
+	}
+	if refreshBefore <= 0 || refreshBefore >= ttl {
+		refreshBefore = ttl / defaultRefreshBeforeRatio
+	}
+	return &cachedSecret{
+		fn:            fn,
+		ttl:           ttl,
+		refreshBefore: refreshBefore,
+	}
+}
+
+func (s *cachedSecret) Password(ctx context.Context) (string, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if s.value != "" && now.Before(s.expiresAt) {
+		value := s.value
+		if !s.refreshing && now.After(s.expiresAt.Add(-s.refreshBefore)) {
+			s.refreshing = true
+			go s.refresh()
+		}
+		s.mu.Unlock()
+		return value, nil
+	}
+	s.mu.Unlock()
+
+	return s.fetch(ctx)
+}
+
+// fetch calls fn once for all callers waiting on an expired password
+func (s *cachedSecret) fetch(ctx context.Context) (string, error) {
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	s.mu.Lock()
+	if s.value != "" && time.Now().Before(s.expiresAt) {
+		value := s.value
+		s.mu.Unlock()
+		return value, nil
+	}
+	s.mu.Unlock()
+
+	value, err := s.fn(ctx)
+	if err != nil {
+		return "", errors.WithMessage(err, "password func")
+	}
+
+	s.mu.Lock()
+	s.value = value
+	s.expiresAt = time.Now().Add(s.ttl)
+	s.mu.Unlock()
+	return value, nil
+}
+
+// refresh renews the password ahead of expiry, on failure the cached one is served until it expires
+func (s *cachedSecret) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordRefreshTimeout)
+	defer cancel()
+
+	s.fetchMu.Lock()
+	value, err := s.fn(ctx)
+	s.mu.Lock()
+	if err == nil {
+		s.value = value
+		s.expiresAt = time.Now().Add(s.ttl)
+	}
+	s.refreshing = false
+	s.mu.Unlock()
+	s.fetchMu.Unlock()
+}
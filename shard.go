@@ -0,0 +1,259 @@
+package wgorm
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// ErrShardKeyRequired is returned by statements run on a Cluster without OnShard
+var ErrShardKeyRequired = errors.New("wgorm: shard key required, use OnShard")
+
+// ShardFunc maps a shard key to the index of its shard, a negative index means the key has no shard
+type ShardFunc func(key interface{}) int
+
+// HashShard spreads keys over n shards by the fnv hash of their string form, pointers are hashed
+// by the value they point to. It returns nil when n is below 1, which NewCluster rejects.
+func HashShard(n int) ShardFunc {
+	if n < 1 {
+		return nil
+	}
+	return func(key interface{}) int {
+		v := reflect.ValueOf(key)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.IsValid() {
+			key = v.Interface()
+		}
+		h := fnv.New32a()
+		h.Write([]byte(fmt.Sprint(key)))
+		return int(h.Sum32() % uint32(n))
+	}
+}
+
+// RangeShard puts integer keys below upper[i] on shard i and the keys above the last bound
+// on shard len(upper). Keys that are not integers have no shard.
+func RangeShard(upper ...int64) ShardFunc {
+	return func(key interface{}) int {
+		k, ok := shardInt(key)
+		if !ok {
+			return -1
+		}
+		for i, u := range upper {
+			if k < u {
+				return i
+			}
+		}
+		return len(upper)
+	}
+}
+
+// LookupShard looks the key up in table, keys missing from it go to fallback.
+// A nil fallback leaves them without a shard.
+func LookupShard(table map[interface{}]int, fallback ShardFunc) ShardFunc {
+	return func(key interface{}) int {
+		if i, ok := table[key]; ok {
+			return i
+		}
+		if fallback == nil {
+			return -1
+		}
+		return fallback(key)
+	}
+}
+
+func shardInt(key interface{}) (int64, bool) {
+	v := reflect.ValueOf(key)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v.Uint()), true
+	case reflect.String:
+		n, err := strconv.ParseInt(v.String(), 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// Cluster is a set of databases holding disjoint parts of the same tables,
+// each shard is a master with its slaves configured like a single database
+type Cluster struct {
+	m         *Manager
+	shards    []*Gorm
+	shardFunc ShardFunc
+}
+
+// NewCluster connects to all shards concurrently, shardFunc picks the shard of a key
+func NewCluster(cfgs []*Config, shardFunc ShardFunc, opts ...ManagerOption) (*Cluster, error) {
+	if len(cfgs) == 0 {
+		return nil, errors.WithStack(fmt.Errorf("cluster needs at least one shard"))
+	}
+	if shardFunc == nil {
+		return nil, errors.WithStack(fmt.Errorf("cluster needs a shard func, HashShard gives none for less than one shard"))
+	}
+
+	named := make(map[string]*Config, len(cfgs))
+	for i, cfg := range cfgs {
+		named[shardName(i)] = cfg
+	}
+	m, err := NewManager(named, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{
+		m:         m,
+		shards:    make([]*Gorm, len(cfgs)),
+		shardFunc: shardFunc,
+	}
+	for i := range cfgs {
+		c.shards[i] = m.dbs[shardName(i)]
+	}
+	return c, nil
+}
+
+func shardName(i int) string {
+	return fmt.Sprintf("shard[%d]", i)
+}
+
+// WithContext returns a Gorm that has to be routed with OnShard before running any statement.
+// Its Begin, Reload, WatchConfig and Close return ErrShardKeyRequired and its Health reports it,
+// use the methods of the Cluster or of a Shard instead.
+func (c *Cluster) WithContext(ctx context.Context) *Gorm {
+	db := c.shards[0].conn.db.WithContext(ctx)
+	db.AddError(ErrShardKeyRequired)
+	return &Gorm{
+		DB:       db,
+		cluster:  c,
+		unrouted: db,
+	}
+}
+
+// Shard returns the shard at index i
+func (c *Cluster) Shard(i int) (*Gorm, error) {
+	if i < 0 || i >= len(c.shards) {
+		return nil, errors.WithStack(fmt.Errorf("shard %d out of range [0, %d)", i, len(c.shards)))
+	}
+	return c.shards[i], nil
+}
+
+// Len returns the number of shards
+func (c *Cluster) Len() int {
+	return len(c.shards)
+}
+
+// ShardOf returns the index of the shard holding key
+func (c *Cluster) ShardOf(key interface{}) (int, error) {
+	i := c.shardFunc(key)
+	if i < 0 || i >= len(c.shards) {
+		return -1, errors.WithStack(fmt.Errorf("no shard for key %v", key))
+	}
+	return i, nil
+}
+
+// OnShard routes g to the shard holding key. The statement is started over on the shard,
+// so OnShard fails it when any other Option or gorm method was applied to g before.
+func OnShard(key interface{}) Option {
+	return func(g *Gorm) *Gorm {
+		if g.cluster == nil {
+			g = g.clone()
+			g.DB = g.DB.Session(&gorm.Session{})
+			g.DB.AddError(errors.WithStack(fmt.Errorf("OnShard used on a Gorm that is not part of a cluster")))
+			return g
+		}
+		if g.DB != g.unrouted {
+			g = g.clone()
+			g.DB = g.DB.Session(&gorm.Session{})
+			g.DB.AddError(errors.WithStack(fmt.Errorf("OnShard must be applied first to the Gorm returned by Cluster.WithContext")))
+			return g
+		}
+
+		ctx := g.DB.Statement.Context
+		i, err := g.cluster.ShardOf(key)
+		if err != nil {
+			g = g.clone()
+			g.DB = g.DB.Session(&gorm.Session{})
+			g.DB.AddError(err)
+			return g
+		}
+		return g.cluster.shards[i].WithContext(ctx)
+	}
+}
+
+// Each runs fn on every shard concurrently and returns the errors of the failed ones
+func (c *Cluster) Each(ctx context.Context, fn func(shard int, g *Gorm) error) error {
+	errs := make([]error, len(c.shards))
+	var wg sync.WaitGroup
+	for i, shard := range c.shards {
+		wg.Add(1)
+		go func(i int, shard *Gorm) {
+			defer wg.Done()
+			errs[i] = fn(i, shard.WithContext(ctx))
+		}(i, shard)
+	}
+	wg.Wait()
+
+	var msgs []string
+	for i, err := range errs {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", shardName(i), err))
+		}
+	}
+	if len(msgs) > 0 {
+		return errors.WithStack(fmt.Errorf("shards failed: %v", msgs))
+	}
+	return nil
+}
+
+// Gather runs the query built by fn on every shard and appends the rows found to dest,
+// a pointer to a slice, in shard order. The results are only concatenated: ORDER BY, LIMIT and OFFSET
+// apply to each shard, so dest holds up to a limit of rows per shard sorted within each shard, and
+// aggregates such as COUNT or SUM give a row per shard that the caller has to combine.
+func (c *Cluster) Gather(ctx context.Context, dest interface{}, fn func(g *Gorm) *gorm.DB) error {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return errors.WithStack(fmt.Errorf("gather dest must be a pointer to a slice, got %T", dest))
+	}
+
+	parts := make([]reflect.Value, len(c.shards))
+	err := c.Each(ctx, func(shard int, g *Gorm) error {
+		part := reflect.New(rv.Elem().Type())
+		if err := fn(g).Find(part.Interface()).Error; err != nil {
+			return err
+		}
+		parts[shard] = part.Elem()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	out := rv.Elem()
+	for _, part := range parts {
+		out = reflect.AppendSlice(out, part)
+	}
+	rv.Elem().Set(out)
+	return nil
+}
+
+// Health checks all shards concurrently
+func (c *Cluster) Health(ctx context.Context) ManagerHealth {
+	return c.m.Health(ctx)
+}
+
+// Close closes every shard
+func (c *Cluster) Close() error {
+	return c.m.Close()
+}
@@ -0,0 +1,148 @@
+package wgorm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestHashShard(t *testing.T) {
+	if HashShard(0) != nil || HashShard(-1) != nil {
+		t.Error("HashShard without shards returned a ShardFunc")
+	}
+	if _, err := NewCluster([]*Config{{}}, HashShard(0)); err == nil || !strings.Contains(err.Error(), "shard func") {
+		t.Errorf("NewCluster with HashShard(0) error = %v, want a shard func error", err)
+	}
+
+	f := HashShard(4)
+	id, name := int64(42), "alice"
+	tests := []struct {
+		name string
+		a, b interface{}
+	}{
+		{name: "same key", a: int64(42), b: int64(42)},
+		{name: "pointer to int", a: &id, b: id},
+		{name: "pointer to string", a: &name, b: name},
+		{name: "pointer to pointer", a: func() **int64 { p := &id; return &p }(), b: id},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if a, b := f(tt.a), f(tt.b); a != b {
+				t.Errorf("shard of %v = %d, shard of %v = %d, want the same", tt.a, a, tt.b, b)
+			}
+		})
+	}
+
+	seen := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		s := f(i)
+		if s < 0 || s >= 4 {
+			t.Fatalf("shard of %d = %d, want [0, 4)", i, s)
+		}
+		seen[s] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("100 keys went to %d shards, want 4", len(seen))
+	}
+}
+
+func TestRangeShard(t *testing.T) {
+	f := RangeShard(100, 200)
+	tests := []struct {
+		key  interface{}
+		want int
+	}{
+		{key: -5, want: 0},
+		{key: int64(99), want: 0},
+		{key: int32(100), want: 1},
+		{key: uint8(150), want: 1},
+		{key: uint(200), want: 2},
+		{key: "250", want: 2},
+		{key: uint64(math.MaxInt64), want: 2},
+		{key: uint64(math.MaxUint64), want: -1},
+		{key: "abc", want: -1},
+		{key: 1.5, want: -1},
+		{key: nil, want: -1},
+	}
+	for _, tt := range tests {
+		if got := f(tt.key); got != tt.want {
+			t.Errorf("shard of %#v = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestLookupShard(t *testing.T) {
+	table := map[interface{}]int{"eu": 0, "us": 1}
+	tests := []struct {
+		name     string
+		fallback ShardFunc
+		key      interface{}
+		want     int
+	}{
+		{name: "in table", key: "us", want: 1},
+		{name: "missing without fallback", key: "asia", want: -1},
+		{name: "missing with fallback", fallback: RangeShard(10), key: 15, want: 1},
+		{name: "in table with fallback", fallback: RangeShard(10), key: "eu", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LookupShard(table, tt.fallback)(tt.key); got != tt.want {
+				t.Errorf("shard of %v = %d, want %d", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardOf(t *testing.T) {
+	c := &Cluster{shards: make([]*Gorm, 2), shardFunc: RangeShard(10, 20)}
+	tests := []struct {
+		key     interface{}
+		want    int
+		wantErr bool
+	}{
+		{key: 5, want: 0},
+		{key: 15, want: 1},
+		{key: 25, wantErr: true},
+		{key: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := c.ShardOf(tt.key)
+		if (err != nil) != tt.wantErr || got != tt.want && !tt.wantErr {
+			t.Errorf("ShardOf(%v) = %d, %v, want %d and error %v", tt.key, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestOnShard(t *testing.T) {
+	conns := []*testConn{{columns: []string{"id", "name"}}, {columns: []string{"id", "name"}}}
+	c := &Cluster{shardFunc: RangeShard(10)}
+	for _, conn := range conns {
+		c.shards = append(c.shards, testGorm(t, &Config{Driver: Postgres}, conn))
+	}
+	ctx := context.Background()
+
+	if err := c.WithContext(ctx).GormDB().Find(&[]pageUser{}).Error; !errors.Is(err, ErrShardKeyRequired) {
+		t.Errorf("unrouted statement error = %v, want ErrShardKeyRequired", err)
+	}
+
+	if err := c.WithContext(ctx).Options(OnShard(15), SetForUpdate()).GormDB().Find(&[]pageUser{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := `SELECT * FROM "page_users" FOR UPDATE`; conns[1].query != want || conns[0].query != "" {
+		t.Errorf("shard queries = %q and %q, want %s on shard 1", conns[0].query, conns[1].query, want)
+	}
+
+	err := c.WithContext(ctx).Options(SetForUpdate(), OnShard(15)).GormDB().Find(&[]pageUser{}).Error
+	if err == nil || !strings.Contains(err.Error(), "OnShard") {
+		t.Errorf("OnShard after another Option error = %v", err)
+	}
+	err = c.WithContext(ctx).Options(OnShard(15), OnShard(15)).GormDB().Find(&[]pageUser{}).Error
+	if err == nil || !strings.Contains(err.Error(), "OnShard") {
+		t.Errorf("OnShard twice error = %v", err)
+	}
+	if err := c.WithContext(ctx).Options(OnShard("x")).GormDB().Find(&[]pageUser{}).Error; err == nil {
+		t.Error("OnShard with a key without shard succeeded")
+	}
+}
@@ -0,0 +1,140 @@
+package wgorm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const sortKey = "wgorm:sort"
+
+// ErrInvalidSort is matched by errors.Is for every SortError
+var ErrInvalidSort = errors.New("wgorm: invalid sort")
+
+// SortError rejects a sort spec given to Sort or ParseSort
+type SortError struct {
+	// Field is the API field name of the spec that was rejected
+	Field  string
+	Reason string
+}
+
+func (e *SortError) Error() string {
+	return fmt.Sprintf("invalid sort field %q: %s", e.Field, e.Reason)
+}
+
+func (e *SortError) Is(target error) bool {
+	return target == ErrInvalidSort
+}
+
+// ParseSort turns a sort spec such as -created_at,email into the columns of allowed, the API field names
+// mapped to their column, each followed by ASC or DESC, e.g. "created_at DESC" and "email ASC".
+// A field prefixed with - sorts descending, + or no prefix ascending. A field missing from allowed,
+// given twice or empty is a *SortError. The result can be given to Cursor.
+func ParseSort(spec string, allowed map[string]string) ([]string, error) {
+	var columns []string
+	seen := make(map[string]bool)
+	for i, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		field, dir := item, "ASC"
+		switch {
+		case strings.HasPrefix(item, "-"):
+			field, dir = item[1:], "DESC"
+		case strings.HasPrefix(item, "+"):
+			field = item[1:]
+		}
+		if field == "" {
+			return nil, errors.WithStack(&SortError{Field: item, Reason: fmt.Sprintf("empty field at position %d of %q", i+1, spec)})
+		}
+		column, ok := allowed[field]
+		if !ok {
+			return nil, errors.WithStack(&SortError{Field: field, Reason: "unknown field"})
+		}
+		if !filterColumn.MatchString(column) {
+			return nil, errors.WithStack(&SortError{Field: field, Reason: fmt.Sprintf("invalid column %q", column)})
+		}
+		if seen[field] {
+			return nil, errors.WithStack(&SortError{Field: field, Reason: "given twice"})
+		}
+		seen[field] = true
+		columns = append(columns, column+" "+dir)
+	}
+	return columns, nil
+}
+
+// Sort orders the statement by a sort spec taken from an API request, see ParseSort. Only the columns
+// of allowed can be ordered by, an invalid spec fails the statement with a *SortError. The primary key
+// of the model is appended to the order so rows with equal sort columns keep a stable order.
+// An empty spec leaves the statement unordered.
+func Sort(spec string, allowed map[string]string) Option {
+	return func(g *Gorm) *Gorm {
+		if strings.TrimSpace(spec) == "" {
+			return g
+		}
+		columns, err := ParseSort(spec, allowed)
+		if err != nil {
+			g = g.clone()
+			g.DB = g.DB.Session(&gorm.Session{})
+			g.DB.AddError(err)
+			return g
+		}
+		orderBy := clause.OrderBy{}
+		for _, c := range columns {
+			parts := strings.Fields(c)
+			orderBy.Columns = append(orderBy.Columns, clause.OrderByColumn{
+				Column: clause.Column{Name: parts[0]},
+				Desc:   parts[1] == "DESC",
+			})
+		}
+		tx := g.DB.Clauses(orderBy).Set(sortKey, true)
+		return g.clone().setDB(tx)
+	}
+}
+
+// sortTieBreaker appends the primary key columns missing from the order of a Sort query,
+// the model is only known once the statement runs
+func sortTieBreaker(db *gorm.DB) {
+	if _, ok := db.Get(sortKey); !ok || db.Error != nil {
+		return
+	}
+	stmt := db.Statement
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Struct:
+	default:
+		// e.g. Count
+		return
+	}
+	c, ok := stmt.Clauses["ORDER BY"]
+	orderBy, isOrderBy := c.Expression.(clause.OrderBy)
+	if !ok || !isOrderBy || stmt.Schema == nil {
+		return
+	}
+
+	ordered := make(map[string]bool)
+	for _, col := range orderBy.Columns {
+		name := col.Column.Name
+		// First and Last order by the primary key placeholder
+		if name == clause.PrimaryKey {
+			for _, f := range stmt.Schema.PrimaryFields {
+				ordered[f.DBName] = true
+			}
+			continue
+		}
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+		ordered[name] = true
+	}
+	for _, f := range stmt.Schema.PrimaryFields {
+		if !ordered[f.DBName] {
+			orderBy.Columns = append(orderBy.Columns, clause.OrderByColumn{
+				Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName},
+			})
+		}
+	}
+	c.Expression = orderBy
+	stmt.Clauses["ORDER BY"] = c
+}
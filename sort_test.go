@@ -0,0 +1,111 @@
+package wgorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSort(t *testing.T) {
+	allowed := map[string]string{
+		"created_at": "created_at",
+		"email":      "users.email",
+		"name":       "name",
+		"bad":        "name; DROP TABLE users",
+	}
+	tests := []struct {
+		spec    string
+		want    []string
+		wantErr string
+	}{
+		{spec: "created_at", want: []string{"created_at ASC"}},
+		{spec: "-created_at,+name", want: []string{"created_at DESC", "name ASC"}},
+		{spec: " -email , name ", want: []string{"users.email DESC", "name ASC"}},
+		{spec: "age", wantErr: `invalid sort field "age": unknown field`},
+		{spec: "Name", wantErr: `invalid sort field "Name": unknown field`},
+		{spec: "name,-name", wantErr: `invalid sort field "name": given twice`},
+		{spec: "name,,email", wantErr: `invalid sort field "": empty field at position 2 of "name,,email"`},
+		{spec: "-", wantErr: `invalid sort field "-": empty field at position 1 of "-"`},
+		{spec: "", wantErr: `invalid sort field "": empty field at position 1 of ""`},
+		{spec: "--name", wantErr: `invalid sort field "-name": unknown field`},
+		{spec: "bad", wantErr: `invalid sort field "bad": invalid column "name; DROP TABLE users"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseSort(tt.spec, allowed)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrInvalidSort) {
+					t.Fatalf("ParseSort(%q) error = %v, want ErrInvalidSort", tt.spec, err)
+				}
+				var serr *SortError
+				if !errors.As(err, &serr) || serr.Error() != tt.wantErr {
+					t.Errorf("ParseSort(%q) error = %v, want %s", tt.spec, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSort(%q) error = %v", tt.spec, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSort(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSort(t *testing.T) {
+	conn := &testConn{columns: []string{"id", "name"}, rows: [][]driver.Value{{int64(1), "a"}}}
+	g := testGorm(t, &Config{Driver: Postgres}, conn)
+	ctx := context.Background()
+	allowed := map[string]string{"name": "name", "id": "id"}
+
+	tests := []struct {
+		name  string
+		spec  string
+		query func(g *Gorm) error
+		want  string
+	}{
+		{
+			name:  "primary key appended",
+			spec:  "-name",
+			query: func(g *Gorm) error { return g.GormDB().Find(&[]pageUser{}).Error },
+			want:  `SELECT * FROM "page_users" ORDER BY "name" DESC,"page_users"."id"`,
+		},
+		{
+			name:  "primary key already ordered",
+			spec:  "name,-id",
+			query: func(g *Gorm) error { return g.GormDB().Find(&[]pageUser{}).Error },
+			want:  `SELECT * FROM "page_users" ORDER BY "name","id" DESC`,
+		},
+		{
+			name:  "first orders by the primary key once",
+			spec:  "-name",
+			query: func(g *Gorm) error { return g.GormDB().First(&pageUser{}).Error },
+			want:  `SELECT * FROM "page_users" ORDER BY "name" DESC,"page_users"."id" LIMIT 1`,
+		},
+		{
+			name:  "empty spec",
+			spec:  " ",
+			query: func(g *Gorm) error { return g.GormDB().Find(&[]pageUser{}).Error },
+			want:  `SELECT * FROM "page_users"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.query(g.WithContext(ctx).Options(Sort(tt.spec, allowed))); err != nil {
+				t.Fatal(err)
+			}
+			if conn.query != tt.want {
+				t.Errorf("SQL = %s, want %s", conn.query, tt.want)
+			}
+		})
+	}
+
+	err := g.WithContext(ctx).Options(Sort("email", allowed)).GormDB().Find(&[]pageUser{}).Error
+	if !errors.Is(err, ErrInvalidSort) || !strings.Contains(err.Error(), "email") {
+		t.Errorf("unknown field error = %v, want a SortError for email", err)
+	}
+}
@@ -0,0 +1,157 @@
+package wgorm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const tenantSchemaKey = "wgorm:tenant_schema"
+
+// ErrTenantNeedsTransaction is returned for tenant statements that can not be routed by table prefixing,
+// raw SQL and joins, run them on a Gorm returned by Begin where the search_path is set
+var ErrTenantNeedsTransaction = errors.New("wgorm: statement needs a transaction to run in the tenant schema")
+
+// sessionSearchPath matches statements changing the search_path beyond the current transaction
+var sessionSearchPath = regexp.MustCompile(`(?i)\bset\s+(session\s+)?search_path\b|set_config\s*\(\s*'search_path'\s*,[^)]*,\s*false\s*\)`)
+
+// TenantResolver returns the schema of a tenant
+type TenantResolver func(ctx context.Context, tenantID string) (string, error)
+
+type tenantCtxKey struct{}
+
+// WithTenant makes the statements run with ctx use the schema of tenantID:
+// transactions started by Begin set a local search_path, other statements have their table prefixed
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant set by WithTenant
+func TenantFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	tenantID, ok := ctx.Value(tenantCtxKey{}).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+// tenantSchema resolves the schema of the tenant of ctx, ok is false when ctx has no tenant
+// or schema routing is off, i.e. neither a schema prefix nor a resolver is configured
+func (cfg *Config) tenantSchema(ctx context.Context) (schema string, ok bool, err error) {
+	if cfg.TenantSchemaPrefix == "" && cfg.TenantResolver == nil {
+		return "", false, nil
+	}
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return "", false, nil
+	}
+
+	schema = cfg.TenantSchemaPrefix + tenantID
+	if cfg.TenantResolver != nil {
+		if schema, err = cfg.TenantResolver(ctx, tenantID); err != nil {
+			return "", true, errors.WithMessagef(err, "resolve schema of tenant %q", tenantID)
+		}
+	}
+	if schema == "" || strings.ContainsAny(schema, `."`) {
+		return "", true, errors.WithStack(fmt.Errorf("invalid schema %q for tenant %q", schema, tenantID))
+	}
+	return schema, true, nil
+}
+
+// tenantSearchPath puts the tenant schema in front of the configured search_path
+func (cfg *Config) tenantSearchPath(schema string) string {
+	path := quoteIdent(schema)
+	if cfg.Master.SearchPath != "" {
+		path += ", " + cfg.Master.SearchPath
+	}
+	return path
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+type tenantHook struct {
+	conn *connection
+}
+
+func newTenantHook(conn *connection) *tenantHook {
+	return &tenantHook{
+		conn: conn,
+	}
+}
+
+// schema returns the tenant schema a statement still has to be routed to,
+// empty when it has no tenant or runs in a transaction that set the search_path
+func (h *tenantHook) schema(db *gorm.DB) string {
+	if db.Error != nil {
+		return ""
+	}
+	schema, ok, err := h.conn.config().tenantSchema(db.Statement.Context)
+	if err != nil {
+		db.AddError(err)
+		return ""
+	}
+	if !ok {
+		return ""
+	}
+	if v, ok := db.Get(tenantSchemaKey); ok && v == schema {
+		return ""
+	}
+	return schema
+}
+
+// prefix qualifies the table of the statement with the tenant schema
+func (h *tenantHook) prefix(db *gorm.DB) {
+	schema := h.schema(db)
+	if schema == "" {
+		return
+	}
+
+	stmt := db.Statement
+	if len(stmt.Joins) > 0 {
+		db.AddError(ErrTenantNeedsTransaction)
+		return
+	}
+	switch {
+	case stmt.Table == "":
+		db.AddError(ErrTenantNeedsTransaction)
+		return
+	case strings.Contains(stmt.Table, "."):
+		// already qualified
+		return
+	}
+
+	// a TableExpr holding more than the quoted table name can not be rewritten
+	if stmt.TableExpr != nil && stmt.TableExpr.SQL != stmt.Quote(stmt.Table) {
+		db.AddError(ErrTenantNeedsTransaction)
+		return
+	}
+	stmt.Table = schema + "." + stmt.Table
+	if stmt.TableExpr != nil {
+		stmt.TableExpr = &clause.Expr{SQL: stmt.Quote(stmt.Table)}
+	}
+}
+
+// raw refuses raw SQL of a tenant outside a transaction and session wide search_path changes,
+// so a pooled connection never carries a tenant's search_path to its next borrower
+func (h *tenantHook) raw(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	if _, ok := TenantFromContext(db.Statement.Context); !ok {
+		return
+	}
+	if sessionSearchPath.MatchString(db.Statement.SQL.String()) {
+		db.AddError(errors.WithStack(fmt.Errorf("search_path can only be changed with SET LOCAL inside a transaction")))
+		return
+	}
+	if h.schema(db) != "" {
+		db.AddError(ErrTenantNeedsTransaction)
+	}
+}
@@ -0,0 +1,210 @@
+package wgorm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	timeoutSettingKey = "wgorm:timeout"
+	timeoutCancelKey  = "wgorm:timeout_cancel"
+
+	// pgQueryCanceled is returned by postgres for statement_timeout and canceled queries
+	pgQueryCanceled = "57014"
+)
+
+// ErrQueryTimeout is matched by errors.Is for every statement that ran out of time,
+// either on the client deadline or on the server statement_timeout
+var ErrQueryTimeout = errors.New("query timeout")
+
+// TimeoutError wraps the driver error of a statement that ran out of time
+type TimeoutError struct {
+	// Timeout applied to the statement, zero when the timeout came from the caller's context or the server
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("query timeout after %v: %v", e.Timeout, e.Err)
+	}
+	return fmt.Sprintf("query timeout: %v", e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrQueryTimeout
+}
+
+// WithTimeout bounds every statement run with the option to d, a transaction begun with it
+// gets d as its statement_timeout
+func WithTimeout(d time.Duration) Option {
+	return func(g *Gorm) *Gorm {
+		tx := g.DB.Set(timeoutSettingKey, d)
+		return g.clone().setDB(tx)
+	}
+}
+
+type timeoutState struct {
+	parent  context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	// rows is set for Row, Rows and Scan statements, their timeout is released when the rows close
+	rows bool
+}
+
+// rowsReleaseKey holds the func releasing the timeout context of a row statement,
+// pooledConn calls it when the rows of the statement are closed
+type rowsReleaseKey struct{}
+
+type timeoutHook struct {
+	conn *connection
+}
+
+func newTimeoutHook(conn *connection) *timeoutHook {
+	return &timeoutHook{
+		conn: conn,
+	}
+}
+
+// before replaces the statement context with one bounded by the timeout,
+// the default timeout only applies when the caller's context has no deadline
+func (h *timeoutHook) before(db *gorm.DB) {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var timeout time.Duration
+	if v, ok := db.Get(timeoutSettingKey); ok {
+		timeout, _ = v.(time.Duration)
+	} else if _, ok := ctx.Deadline(); !ok {
+		timeout = time.Duration(h.conn.config().DefaultQueryTimeoutMs) * time.Millisecond
+	}
+	if timeout <= 0 {
+		return
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	// keep the original context so the statement can be reused after this call
+	db.InstanceSet(timeoutCancelKey, &timeoutState{
+		parent:  db.Statement.Context,
+		cancel:  cancel,
+		timeout: timeout,
+	})
+	db.Statement.Context = timeoutCtx
+}
+
+// rowBefore bounds a Row, Rows or Scan statement like before. Its rows are read after the callbacks
+// return, so the timeout context is released when they are closed rather than by after.
+func (h *timeoutHook) rowBefore(db *gorm.DB) {
+	h.before(db)
+	v, ok := db.InstanceGet(timeoutCancelKey)
+	if !ok {
+		return
+	}
+	state, ok := v.(*timeoutState)
+	if !ok || state == nil {
+		return
+	}
+	var once sync.Once
+	cancel := state.cancel
+	state.cancel = func() { once.Do(cancel) }
+	state.rows = true
+	db.Statement.Context = context.WithValue(db.Statement.Context, rowsReleaseKey{}, state.cancel)
+}
+
+// after releases the timeout context and turns timeouts into TimeoutError,
+// the context of rows returned by a row statement is left to them
+func (h *timeoutHook) after(db *gorm.DB) {
+	var timeout time.Duration
+	ctx := db.Statement.Context
+	if v, ok := db.InstanceGet(timeoutCancelKey); ok {
+		if state, ok := v.(*timeoutState); ok && state != nil {
+			if !state.rows || !hasRows(db) {
+				state.cancel()
+			}
+			timeout = state.timeout
+			db.Statement.Context = state.parent
+			db.InstanceSet(timeoutCancelKey, (*timeoutState)(nil))
+		}
+	}
+
+	if db.Error != nil && isTimeout(ctx, db.Error) {
+		var te *TimeoutError
+		if !errors.As(db.Error, &te) {
+			db.Error = &TimeoutError{
+				Timeout: timeout,
+				Err:     db.Error,
+			}
+		}
+	}
+}
+
+// hasRows reports whether a row statement returned rows that still use its context
+func hasRows(db *gorm.DB) bool {
+	if db.Error != nil {
+		return false
+	}
+	switch dest := db.Statement.Dest.(type) {
+	case *sql.Rows:
+		return dest != nil
+	case *sql.Row:
+		return dest != nil && dest.Err() == nil
+	}
+	return false
+}
+
+// isTimeout reports whether err ended a statement run with ctx because a deadline expired.
+// Queries canceled by pg_cancel_backend or a canceled context are not timeouts.
+func isTimeout(ctx context.Context, err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgQueryCanceled {
+		return false
+	}
+	// the driver cancels the query on the server when the context is done
+	if ctx != nil && ctx.Err() == context.DeadlineExceeded {
+		return true
+	}
+	return strings.Contains(pgErr.Message, "statement timeout")
+}
+
+// statementTimeout is the server side statement_timeout in milliseconds for a transaction started with ctx,
+// timeout is the one set by WithTimeout, zero when it was not used
+func (cfg *Config) statementTimeout(ctx context.Context, timeout time.Duration) int64 {
+	var ms int64
+	deadline, hasDeadline := ctx.Deadline()
+	switch {
+	case timeout > 0:
+		ms = timeout.Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+	case !hasDeadline:
+		ms = int64(cfg.DefaultQueryTimeoutMs)
+	}
+	if hasDeadline {
+		left := time.Until(deadline).Milliseconds()
+		if left < 1 {
+			left = 1
+		}
+		if ms <= 0 || left < ms {
+			ms = left
+		}
+	}
+	return ms
+}
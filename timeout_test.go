@@ -0,0 +1,84 @@
+package wgorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestRowStatementTimeout(t *testing.T) {
+	conn := &testConn{columns: []string{"n"}}
+	g := testGorm(t, &Config{Driver: Postgres, DefaultQueryTimeoutMs: 5000}, conn)
+	callerCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		opts []Option
+		run  func(g *Gorm) error
+		// want is the time left of the statement deadline, zero for none
+		want time.Duration
+	}{
+		{
+			name: "raw scan gets the default timeout",
+			ctx:  context.Background(),
+			run: func(g *Gorm) error {
+				var n int
+				return g.GormDB().Raw("SELECT 1").Scan(&n).Error
+			},
+			want: 5 * time.Second,
+		},
+		{
+			name: "row gets the default timeout",
+			ctx:  context.Background(),
+			run: func(g *Gorm) error {
+				var n int
+				return g.GormDB().Raw("SELECT 1").Row().Scan(&n)
+			},
+			want: 5 * time.Second,
+		},
+		{
+			name: "rows get the timeout of WithTimeout",
+			ctx:  context.Background(),
+			opts: []Option{WithTimeout(time.Second)},
+			run: func(g *Gorm) error {
+				rows, err := g.GormDB().Raw("SELECT 1").Rows()
+				if err != nil {
+					return err
+				}
+				return rows.Close()
+			},
+			want: time.Second,
+		},
+		{
+			name: "the deadline of the caller is kept",
+			ctx:  callerCtx,
+			run: func(g *Gorm) error {
+				var n int
+				return g.GormDB().Raw("SELECT 1").Scan(&n).Error
+			},
+			want: time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn.rows = [][]driver.Value{{int64(1)}}
+			conn.ctx = nil
+			if err := tt.run(g.WithContext(tt.ctx).Options(tt.opts...)); err != nil {
+				t.Fatal(err)
+			}
+			if conn.ctx == nil {
+				t.Fatal("the statement did not reach the driver")
+			}
+			deadline, ok := conn.ctx.Deadline()
+			if !ok {
+				t.Fatal("the statement ran without a deadline")
+			}
+			if left := time.Until(deadline); left > tt.want || left < tt.want-time.Second {
+				t.Errorf("deadline in %v, want %v", left, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package wgorm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SSLMode is the libpq sslmode
+type SSLMode string
+
+const (
+	SSLModeDisable    SSLMode = "disable"
+	SSLModeAllow      SSLMode = "allow"
+	SSLModePrefer     SSLMode = "prefer"
+	SSLModeRequire    SSLMode = "require"
+	SSLModeVerifyCA   SSLMode = "verify-ca"
+	SSLModeVerifyFull SSLMode = "verify-full"
+)
+
+func (m SSLMode) valid() bool {
+	switch m {
+	case SSLModeDisable, SSLModeAllow, SSLModePrefer, SSLModeRequire, SSLModeVerifyCA, SSLModeVerifyFull:
+		return true
+	}
+	return false
+}
+
+// sslMode resolves SSLMode, falling back to the SSLEnable switch when it is not set
+func (cc *ConnConfig) sslMode() SSLMode {
+	if cc.SSLMode != "" {
+		return cc.SSLMode
+	}
+	if cc.SSLEnable {
+		return SSLModeRequire
+	}
+	return SSLModeDisable
+}
+
+func (cc *ConnConfig) hasTLSMaterial() bool {
+	return cc.SSLRootCert != "" || cc.SSLCert != "" || cc.SSLKey != "" || cc.SSLServerName != ""
+}
+
+// loadPEM returns v itself when it holds PEM data, otherwise reads the file it points to
+func loadPEM(v string) ([]byte, error) {
+	if strings.Contains(v, "-----BEGIN") {
+		return []byte(v), nil
+	}
+	b, err := ioutil.ReadFile(v)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// buildTLSConfig mirrors libpq's sslmode semantics with certificates loaded from files or PEM strings.
+// It returns nil when TLS is disabled or pgx's own handling of the dsn is enough.
+func (cc *ConnConfig) buildTLSConfig() (*tls.Config, error) {
+	mode := cc.sslMode()
+	if !mode.valid() {
+		return nil, fieldErrorf("ssl_mode", "unknown mode %q", mode)
+	}
+	if mode == SSLModeDisable || !cc.hasTLSMaterial() {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: cc.SSLServerName,
+	}
+
+	if cc.SSLRootCert != "" {
+		pem, err := loadPEM(cc.SSLRootCert)
+		if err != nil {
+			return nil, fieldErrorf("ssl_root_cert", "%v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fieldErrorf("ssl_root_cert", "no valid PEM certificate found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (cc.SSLCert == "") != (cc.SSLKey == "") {
+		return nil, fieldErrorf("ssl_key", "ssl_cert and ssl_key must be set together")
+	}
+	if cc.SSLCert != "" {
+		certPEM, err := loadPEM(cc.SSLCert)
+		if err != nil {
+			return nil, fieldErrorf("ssl_cert", "%v", err)
+		}
+		keyPEM, err := loadPEM(cc.SSLKey)
+		if err != nil {
+			return nil, fieldErrorf("ssl_key", "%v", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fieldErrorf("ssl_cert", "%v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch mode {
+	case SSLModeAllow, SSLModePrefer:
+		tlsConfig.InsecureSkipVerify = true
+	case SSLModeRequire:
+		// like libpq, require with a root certificate behaves as verify-ca
+		if tlsConfig.RootCAs == nil {
+			tlsConfig.InsecureSkipVerify = true
+			break
+		}
+		fallthrough
+	case SSLModeVerifyCA:
+		// verify the chain ourselves, skipping the hostname check
+		roots := tlsConfig.RootCAs
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			opts := x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: x509.NewCertPool(),
+			}
+			var leaf *x509.Certificate
+			for i, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return errors.WithStack(fmt.Errorf("failed to parse certificate from server: %v", err))
+				}
+				if i == 0 {
+					leaf = cert
+					continue
+				}
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := leaf.Verify(opts)
+			return err
+		}
+	case SSLModeVerifyFull:
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = cc.Host
+		}
+	}
+
+	return tlsConfig, nil
+}
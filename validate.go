@@ -0,0 +1,190 @@
+package wgorm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/shoyo10/wgorm/logger"
+)
+
+// FieldError is a problem with one config field, Path is its yaml path such as slave[1].port
+type FieldError = logger.FieldError
+
+// defaultPort is the port libpq connects to when none is set
+const defaultPort = 5432
+
+func fieldErrorf(path, format string, args ...interface{}) error {
+	return errors.WithStack(&FieldError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// ValidationError holds every problem found in a Config
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for i := range e.Errors {
+		msgs = append(msgs, e.Errors[i].Error())
+	}
+	return fmt.Sprintf("invalid config: %s", strings.Join(msgs, "; "))
+}
+
+// WithPrefix roots every path at prefix, e.g. the key the config is nested under in the yaml file
+func (e *ValidationError) WithPrefix(prefix string) *ValidationError {
+	errs := make([]FieldError, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		fe.Path = prefix + "." + fe.Path
+		errs = append(errs, fe)
+	}
+	return &ValidationError{Errors: errs}
+}
+
+func (e *ValidationError) add(path, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// addErr records err under prefix, keeping the path of a FieldError
+func (e *ValidationError) addErr(prefix string, err error) {
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		e.Errors = append(e.Errors, FieldError{Path: prefix + "." + fe.Path, Message: fe.Message})
+		return
+	}
+	e.Errors = append(e.Errors, FieldError{Path: prefix, Message: err.Error()})
+}
+
+// Validate checks every field of the config and returns all problems at once as a *ValidationError.
+// Zero values that get a default are accepted.
+func (cfg *Config) Validate() error {
+	verr := &ValidationError{}
+
+	switch cfg.Driver {
+	case "":
+		verr.add("driver", "is required")
+	case Postgres:
+	default:
+		verr.add("driver", "unknown driver %q", cfg.Driver)
+	}
+
+	if cfg.MaxIdleConns < 0 {
+		verr.add("max_idle_conns", "must not be negative")
+	}
+	if cfg.MaxOpenConns < 0 {
+		verr.add("max_open_conns", "must not be negative")
+	}
+	// compare the effective values, zero gets the default
+	maxIdle, maxOpen := cfg.MaxIdleConns, cfg.MaxOpenConns
+	if maxIdle == 0 {
+		maxIdle = defaultMaxIdleConns
+	}
+	if maxOpen == 0 {
+		maxOpen = defaultMaxOpenConns
+	}
+	if maxIdle > 0 && maxOpen > 0 && maxIdle > maxOpen {
+		if cfg.MaxIdleConns == 0 {
+			verr.add("max_open_conns", "must not be below the default max_idle_conns (%d)", maxIdle)
+		} else {
+			verr.add("max_idle_conns", "must not exceed max_open_conns (%d)", maxOpen)
+		}
+	}
+	if cfg.ConnMaxLifeTimeSec < 0 {
+		verr.add("conn_max_life_time_sec", "must not be negative")
+	}
+	if cfg.ConnMaxIdleTimeSec < 0 {
+		verr.add("conn_max_idle_time_sec", "must not be negative")
+	}
+	if cfg.ConnMaxLifeTimeJitterSec < 0 {
+		verr.add("conn_max_life_time_jitter_sec", "must not be negative")
+	}
+	if cfg.ConnMaxLifeTimeSec > 0 && cfg.ConnMaxLifeTimeJitterSec > cfg.ConnMaxLifeTimeSec {
+		verr.add("conn_max_life_time_jitter_sec", "must not exceed conn_max_life_time_sec (%d)", cfg.ConnMaxLifeTimeSec)
+	}
+	if cfg.MinWarmConns < 0 {
+		verr.add("min_warm_conns", "must not be negative")
+	}
+	if cfg.MinWarmConns > 0 && maxIdle > 0 && cfg.MinWarmConns > maxIdle {
+		verr.add("min_warm_conns", "must not exceed max_idle_conns (%d)", maxIdle)
+	}
+	if cfg.MinWarmConns > 0 && maxOpen > 0 && cfg.MinWarmConns > maxOpen {
+		verr.add("min_warm_conns", "must not exceed max_open_conns (%d)", maxOpen)
+	}
+	if cfg.DefaultQueryTimeoutMs < 0 {
+		verr.add("default_query_timeout_ms", "must not be negative")
+	}
+
+	if strings.ContainsAny(cfg.TenantSchemaPrefix, `."`) {
+		verr.add("tenant_schema_prefix", "must not contain . or \"")
+	}
+
+	if cfg.TenantSessionVar != "" && !validSessionVarName(cfg.TenantSessionVar) {
+		verr.add("tenant_session_var", "must be a prefixed name such as app.tenant_id")
+	}
+
+	if cfg.CursorSecret != "" && len(cfg.CursorSecret) < 16 {
+		verr.add("cursor_secret", "must be at least 16 bytes")
+	}
+
+	cfg.Master.validate("master", verr)
+	for i := range cfg.Slave {
+		cfg.Slave[i].validate(fmt.Sprintf("slave[%d]", i), verr)
+	}
+
+	for _, fe := range cfg.Log.Validate() {
+		verr.add("log."+fe.Path, "%s", fe.Message)
+	}
+
+	if len(verr.Errors) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func (cc ConnConfig) validate(path string, verr *ValidationError) {
+	// validate the merged settings, a dsn may provide the required fields
+	if err := cc.mergeDSN(); err != nil {
+		verr.addErr(path, err)
+	}
+
+	if strings.TrimSpace(cc.Host) == "" {
+		verr.add(path+".host", "is required")
+	}
+	// libpq connects to 5432 when neither the field nor the dsn sets a port
+	port := cc.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	if port < 1 || port > 65535 {
+		verr.add(path+".port", "must be between 1 and 65535, got %d", cc.Port)
+	}
+	if strings.TrimSpace(cc.Username) == "" {
+		verr.add(path+".username", "is required")
+	}
+	if strings.TrimSpace(cc.DBName) == "" {
+		verr.add(path+".dbname", "is required")
+	}
+
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"connect_timeout_sec", cc.ConnectTimeoutSec},
+		{"statement_timeout_ms", cc.StatementTimeoutMs},
+		{"lock_timeout_ms", cc.LockTimeoutMs},
+		{"idle_in_transaction_session_timeout_ms", cc.IdleInTransactionSessionTimeoutMs},
+		{"password_ttl_sec", cc.PasswordTTLSec},
+		{"password_refresh_before_sec", cc.PasswordRefreshBeforeSec},
+	} {
+		if f.value < 0 {
+			verr.add(path+"."+f.name, "must not be negative")
+		}
+	}
+
+	if _, err := cc.buildTLSConfig(); err != nil {
+		verr.addErr(path, err)
+	}
+}
@@ -0,0 +1,171 @@
+package wgorm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/shoyo10/wgorm/logger"
+)
+
+func validConfig() *Config {
+	return &Config{
+		Driver: Postgres,
+		Master: ConnConfig{Host: "master", Port: 5432, Username: "app", DBName: "db"},
+		Slave:  []ConnConfig{{Host: "slave", Port: 5432, Username: "app", DBName: "db"}},
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		// paths of the expected field errors in order, none when the config is valid
+		paths []string
+	}{
+		{
+			name:   "valid",
+			modify: func(cfg *Config) {},
+		},
+		{
+			name: "port defaults to 5432",
+			modify: func(cfg *Config) {
+				cfg.Master.Port = 0
+				cfg.Slave[0] = ConnConfig{DSN: "postgres://app@slave/db"}
+			},
+		},
+		{
+			name: "dsn provides the required fields",
+			modify: func(cfg *Config) {
+				cfg.Master = ConnConfig{DSN: "host=master port=6543 user=app dbname=db"}
+			},
+		},
+		{
+			name:   "missing driver",
+			modify: func(cfg *Config) { cfg.Driver = "" },
+			paths:  []string{"driver"},
+		},
+		{
+			name:   "unknown driver",
+			modify: func(cfg *Config) { cfg.Driver = "mysql" },
+			paths:  []string{"driver"},
+		},
+		{
+			name: "negative pool settings",
+			modify: func(cfg *Config) {
+				cfg.MaxIdleConns = -1
+				cfg.MaxOpenConns = -1
+				cfg.ConnMaxLifeTimeSec = -1
+				cfg.ConnMaxIdleTimeSec = -1
+				cfg.MinWarmConns = -1
+				cfg.DefaultQueryTimeoutMs = -1
+			},
+			paths: []string{"max_idle_conns", "max_open_conns", "conn_max_life_time_sec", "conn_max_idle_time_sec", "min_warm_conns", "default_query_timeout_ms"},
+		},
+		{
+			name: "max idle above max open",
+			modify: func(cfg *Config) {
+				cfg.MaxIdleConns = 20
+				cfg.MaxOpenConns = 10
+			},
+			paths: []string{"max_idle_conns"},
+		},
+		{
+			name:   "max idle above the default max open",
+			modify: func(cfg *Config) { cfg.MaxIdleConns = 200 },
+			paths:  []string{"max_idle_conns"},
+		},
+		{
+			name:   "max open below the default max idle",
+			modify: func(cfg *Config) { cfg.MaxOpenConns = 10 },
+			paths:  []string{"max_open_conns"},
+		},
+		{
+			name: "jitter above lifetime",
+			modify: func(cfg *Config) {
+				cfg.ConnMaxLifeTimeSec = 60
+				cfg.ConnMaxLifeTimeJitterSec = 120
+			},
+			paths: []string{"conn_max_life_time_jitter_sec"},
+		},
+		{
+			name: "warm connections above the pool",
+			modify: func(cfg *Config) {
+				cfg.MaxIdleConns = 5
+				cfg.MaxOpenConns = 8
+				cfg.MinWarmConns = 10
+			},
+			paths: []string{"min_warm_conns", "min_warm_conns"},
+		},
+		{
+			name: "tenant and cursor settings",
+			modify: func(cfg *Config) {
+				cfg.TenantSchemaPrefix = "tenant."
+				cfg.TenantSessionVar = "tenant_id"
+				cfg.CursorSecret = "short"
+			},
+			paths: []string{"tenant_schema_prefix", "tenant_session_var", "cursor_secret"},
+		},
+		{
+			name: "connection fields",
+			modify: func(cfg *Config) {
+				cfg.Master = ConnConfig{Port: 70000, LockTimeoutMs: -1}
+			},
+			paths: []string{"master.host", "master.port", "master.username", "master.dbname", "master.lock_timeout_ms"},
+		},
+		{
+			name:   "invalid dsn keeps its field path",
+			modify: func(cfg *Config) { cfg.Slave[0].DSN = "host='slave" },
+			paths:  []string{"slave[0].dsn"},
+		},
+		{
+			name:   "unknown ssl mode",
+			modify: func(cfg *Config) { cfg.Slave[0].SSLMode = "sometimes" },
+			paths:  []string{"slave[0].ssl_mode"},
+		},
+		{
+			name: "log config",
+			modify: func(cfg *Config) {
+				cfg.Log = logger.Config{SlowThresholdMs: -1, RateLimitBurst: 5}
+			},
+			paths: []string{"log.slow_threshold_ms", "log.rate_limit_burst"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if len(tt.paths) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate() error = %v, want a *ValidationError", err)
+			}
+			var paths []string
+			for _, fe := range verr.Errors {
+				paths = append(paths, fe.Path)
+			}
+			if !reflect.DeepEqual(paths, tt.paths) {
+				t.Errorf("Validate() paths = %v, want %v\n%v", paths, tt.paths, err)
+			}
+		})
+	}
+}
+
+func TestValidationErrorWithPrefix(t *testing.T) {
+	verr := &ValidationError{Errors: []FieldError{{Path: "master.host", Message: "is required"}}}
+	got := verr.WithPrefix("database").Error()
+	want := "invalid config: database.master.host: is required"
+	if got != want {
+		t.Errorf("WithPrefix().Error() = %s, want %s", got, want)
+	}
+	if verr.Errors[0].Path != "master.host" {
+		t.Errorf("WithPrefix modified the receiver: %s", verr.Errors[0].Path)
+	}
+}
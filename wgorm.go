@@ -3,6 +3,8 @@ package wgorm
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"time"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -12,29 +14,26 @@ type Option func(g *Gorm) *Gorm
 
 type Gorm struct {
 	*gorm.DB
-	conn connection
-}
-
-type connection struct {
-	db  *gorm.DB
-	cfg *Config
+	conn *connection
+	// cluster is set on the Gorm returned by Cluster.WithContext until OnShard picks a shard
+	cluster *Cluster
+	// unrouted is the statement Cluster.WithContext started, OnShard refuses any other
+	unrouted *gorm.DB
 }
 
 func New(cfg *Config) (*Gorm, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	config, err := cfg.clone()
 	if err != nil {
 		return nil, err
 	}
-	db, err := config.newGormDB()
+	conn, err := newConnection(config)
 	if err != nil {
 		return nil, err
 	}
 
-	conn := connection{
-		db:  db,
-		cfg: config,
-	}
-
 	return &Gorm{
 		conn: conn,
 	}, nil
@@ -54,10 +53,52 @@ func (g *Gorm) WithContext(ctx context.Context) *Gorm {
 }
 
 func (g *Gorm) Begin(ctx context.Context, opts ...*sql.TxOptions) (*Gorm, error) {
-	tx := g.WithContext(ctx).GormDB().Begin(opts...)
+	if g.cluster != nil {
+		return nil, ErrShardKeyRequired
+	}
+	db := g.WithContext(ctx).GormDB()
+	var timeout time.Duration
+	if v, ok := db.Get(timeoutSettingKey); ok {
+		timeout, _ = v.(time.Duration)
+	}
+	tx := db.Begin(opts...)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
+	cfg := g.conn.config()
+	schema, ok, err := cfg.tenantSchema(ctx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if ok {
+		// keep tx a session so the setting is carried to every statement of the transaction
+		tx = tx.Set(tenantSchemaKey, schema).Session(&gorm.Session{})
+		if err := tx.Exec("SET LOCAL search_path TO " + cfg.tenantSearchPath(schema)).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	vars, err := cfg.sessionVars(ctx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if len(vars) > 0 {
+		tx = tx.Set(sessionVarsSettingKey, true).Session(&gorm.Session{})
+		for _, v := range vars {
+			if err := tx.Exec("SELECT set_config(?, ?, true)", v.name, v.value).Error; err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+	if ms := cfg.statementTimeout(ctx, timeout); ms > 0 {
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", ms)).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
 	return &Gorm{
 		DB:   tx,
 		conn: g.conn,
@@ -74,8 +115,10 @@ func (g *Gorm) Rollback() error {
 
 func (g *Gorm) clone() *Gorm {
 	return &Gorm{
-		DB:   g.DB,
-		conn: g.conn,
+		DB:       g.DB,
+		conn:     g.conn,
+		cluster:  g.cluster,
+		unrouted: g.unrouted,
 	}
 }
 